@@ -0,0 +1,200 @@
+package tsdb
+
+import (
+	"container/heap"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// defaultHeadWindow 是可变 head block 覆盖的时间窗口，超过这个窗口 head 就会
+// 被封存成一个不可变 block
+const defaultHeadWindow = 2 * time.Hour
+
+// compactionTiers 描述 block 合并的节奏：每当同一层级里攒够 fanout 个 block，
+// 它们就会被合并成下一层一个更大的 block，按 2h -> 6h -> 1d 收敛，这样 block
+// 数量不会随着 retention 线性增长，单次查询要扫描的 block 数也有上限
+var compactionTiers = []struct {
+	duration time.Duration
+	fanout   int
+}{
+	{duration: 6 * time.Hour, fanout: 3},
+	{duration: 24 * time.Hour, fanout: 4},
+}
+
+// headBounds 记录当前 head block 里最早和最晚样本的时间，用于判断 head 的
+// 时间跨度有没有超过 headWindow
+type headBounds struct {
+	mu  sync.Mutex
+	min int64
+	max int64
+}
+
+func newHeadBounds() *headBounds {
+	return &headBounds{min: math.MaxInt64, max: math.MinInt64}
+}
+
+func (h *headBounds) observe(t int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if t < h.min {
+		h.min = t
+	}
+	if t > h.max {
+		h.max = t
+	}
+}
+
+// span 返回目前观察到的 [min, max]，ok 为 false 表示还没写过任何样本
+func (h *headBounds) span() (min, max int64, ok bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.max < h.min {
+		return 0, 0, false
+	}
+	return h.min, h.max, true
+}
+
+// block 是一段时间窗口内已经封存、不再接受写入的数据。它复用跟 head 完全一样
+// 的 shard+index 存储结构，只是生命周期进入了只读阶段；查询和 compaction 都
+// 靠 [minTime, maxTime] 判断要不要碰它
+type block[T any] struct {
+	minTime int64
+	maxTime int64
+	tier    int // 0 表示直接从 head 封存而来，之后每经过一轮 compaction 加一
+
+	idx   *index
+	store *shard[T]
+}
+
+// sealBlock 把一个活跃中的 head（shard+index+时间窗口）封存成一个不可变
+// block：强制把每个系列还没写满的 head chunk 也封存掉，这样 block 里的 Chunk
+// 全部都是压缩后、不会再变的数据
+func sealBlock[T any](idx *index, store *shard[T], window *headBounds) *block[T] {
+	store.seal()
+
+	min, max, ok := window.span()
+	if !ok {
+		min, max = 0, 0
+	}
+
+	return &block[T]{minTime: min, maxTime: max, idx: idx, store: store}
+}
+
+// overlaps 判断 block 的时间窗口是否跟 [min, max] 有交集
+func (b *block[T]) overlaps(min, max int64) bool {
+	return b.maxTime >= min && b.minTime <= max
+}
+
+// chunkIterHeap 是参与 k-way 归并的若干 ChunkIterator，按当前游标指向的时间
+// 排序，实现方式跟 postingsHeap 一样
+type chunkIterHeap[T any] []ChunkIterator[T]
+
+func (h chunkIterHeap[T]) Len() int           { return len(h) }
+func (h chunkIterHeap[T]) Less(i, j int) bool { return h[i].At().UnixNano < h[j].At().UnixNano }
+func (h chunkIterHeap[T]) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *chunkIterHeap[T]) Push(x any) { *h = append(*h, x.(ChunkIterator[T])) }
+
+func (h *chunkIterHeap[T]) Pop() any {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	*h = old[:n-1]
+	return item
+}
+
+// mergeSeriesChunks 把一个系列在若干个 block 里的 Chunk 用 k-way 归并成一条
+// 全局按时间排序的样本序列。Chunk 按写入顺序遍历，乱序写入时内部不一定有序，
+// 所以归并之后再做一次稳定排序
+func mergeSeriesChunks[T any](chunks []Chunk[T]) []Value[T] {
+	h := make(chunkIterHeap[T], 0, len(chunks))
+	for _, c := range chunks {
+		it := c.Iterator()
+		if it.Next() {
+			h = append(h, it)
+		}
+	}
+	heap.Init(&h)
+
+	var values []Value[T]
+	for len(h) > 0 {
+		top := h[0]
+		values = append(values, top.At())
+		if top.Next() {
+			heap.Fix(&h, 0)
+		} else {
+			heap.Pop(&h)
+		}
+	}
+	sort.SliceStable(values, func(i, j int) bool { return values[i].UnixNano < values[j].UnixNano })
+	return values
+}
+
+// mergeBlocks 把多个 block 合并成一个更大的 block：每个系列的样本用
+// mergeSeriesChunks 做 k-way 归并，再整个重新走一遍压缩；索引按系列 key 重新
+// 分配一套全新的、互不冲突的 ID
+func mergeBlocks[T any](blocks []*block[T]) *block[T] {
+	idx := newIndex()
+	store := newShard[T]()
+
+	minTime, maxTime := int64(math.MaxInt64), int64(math.MinInt64)
+	seriesTags := make(map[string][]Tag)
+
+	for _, b := range blocks {
+		if b.minTime < minTime {
+			minTime = b.minTime
+		}
+		if b.maxTime > maxTime {
+			maxTime = b.maxTime
+		}
+
+		b.idx.forEach(func(_ int, key string, tags []Tag) {
+			if _, ok := seriesTags[key]; !ok {
+				seriesTags[key] = tags
+			}
+		})
+	}
+
+	idx.createSeriesIfNotExists(seriesTags)
+
+	for key := range seriesTags {
+		var chunks []Chunk[T]
+		for _, b := range blocks {
+			chunks = append(chunks, b.store.chunks(key)...)
+		}
+		if values := mergeSeriesChunks(chunks); len(values) > 0 {
+			store.rewrite(key, values)
+		}
+	}
+	store.seal()
+
+	if maxTime < minTime {
+		minTime, maxTime = 0, 0
+	}
+
+	return &block[T]{minTime: minTime, maxTime: maxTime, idx: idx, store: store}
+}
+
+// replaceBlocks 返回把 group 这些 block 从 blocks 里摘掉、换成 merged 之后的
+// 新列表，并按 minTime 重新排序
+func replaceBlocks[T any](blocks []*block[T], group []*block[T], merged *block[T]) []*block[T] {
+	remove := make(map[*block[T]]bool, len(group))
+	for _, b := range group {
+		remove[b] = true
+	}
+
+	out := blocks[:0:0]
+	for _, b := range blocks {
+		if !remove[b] {
+			out = append(out, b)
+		}
+	}
+	out = append(out, merged)
+
+	sort.Slice(out, func(i, j int) bool { return out[i].minTime < out[j].minTime })
+	return out
+}
@@ -0,0 +1,88 @@
+package tsdb
+
+import "testing"
+
+func TestSealBlockFreezesHeadChunks(t *testing.T) {
+	store := newShard[int64]()
+	idx := newIndex()
+	idx.createSeriesIfNotExists(map[string][]Tag{"s": nil})
+
+	window := newHeadBounds()
+	store.writeMulti(map[string][]Value[int64]{
+		"s": {{UnixNano: 1, V: 1}, {UnixNano: 2, V: 2}},
+	})
+	window.observe(1)
+	window.observe(2)
+
+	b := sealBlock(idx, store, window)
+
+	if b.minTime != 1 || b.maxTime != 2 {
+		t.Fatalf("want block span [1,2], got [%d,%d]", b.minTime, b.maxTime)
+	}
+
+	got := b.store.chunks("s")
+	var total int
+	for _, c := range got {
+		it := c.Iterator()
+		for it.Next() {
+			total++
+		}
+	}
+	if total != 2 {
+		t.Fatalf("want 2 sealed samples, got %d", total)
+	}
+}
+
+func TestMergeBlocksKWayMergesAndReassignsIDs(t *testing.T) {
+	a := buildSealedBlock[int64](t, "s", []Value[int64]{{UnixNano: 1, V: 1}, {UnixNano: 3, V: 3}})
+	b := buildSealedBlock[int64](t, "s", []Value[int64]{{UnixNano: 2, V: 2}, {UnixNano: 4, V: 4}})
+
+	merged := mergeBlocks([]*block[int64]{a, b})
+
+	if merged.minTime != 1 || merged.maxTime != 4 {
+		t.Fatalf("want merged span [1,4], got [%d,%d]", merged.minTime, merged.maxTime)
+	}
+
+	got := merged.store.valuesBetween("s", 0, 10)
+	if len(got) != 4 {
+		t.Fatalf("want 4 merged values, got %+v", got)
+	}
+	for i, v := range got {
+		if v.UnixNano != int64(i+1) {
+			t.Fatalf("want merged values sorted by time, got %+v", got)
+		}
+	}
+
+	if _, ok := merged.idx.seriesID("s"); !ok {
+		t.Fatalf("want merged block to have its own index entry for series s")
+	}
+}
+
+func TestReplaceBlocksSwapsGroupForMergedAndSorts(t *testing.T) {
+	a := &block[int64]{minTime: 0, maxTime: 10}
+	b := &block[int64]{minTime: 11, maxTime: 20}
+	c := &block[int64]{minTime: 21, maxTime: 30}
+	merged := &block[int64]{minTime: 0, maxTime: 20}
+
+	out := replaceBlocks([]*block[int64]{a, b, c}, []*block[int64]{a, b}, merged)
+
+	if len(out) != 2 || out[0] != merged || out[1] != c {
+		t.Fatalf("want [merged, c], got %+v", out)
+	}
+}
+
+func buildSealedBlock[T any](t *testing.T, key string, values []Value[T]) *block[T] {
+	t.Helper()
+
+	store := newShard[T]()
+	idx := newIndex()
+	idx.createSeriesIfNotExists(map[string][]Tag{key: nil})
+
+	window := newHeadBounds()
+	store.writeMulti(map[string][]Value[T]{key: values})
+	for _, v := range values {
+		window.observe(v.UnixNano)
+	}
+
+	return sealBlock(idx, store, window)
+}
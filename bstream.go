@@ -0,0 +1,140 @@
+package tsdb
+
+// bstreamWriter 是一个只追加的位流（bit stream），Gorilla 式压缩编码的底层工具。
+type bstreamWriter struct {
+	stream []byte
+	count  uint8 // 最后一个字节里还剩多少位没写，0 表示需要开启新的一个字节
+}
+
+func newBStreamWriter() *bstreamWriter {
+	return &bstreamWriter{}
+}
+
+// writeBit 写入一个 bit。
+func (w *bstreamWriter) writeBit(bit bool) {
+	if w.count == 0 {
+		w.stream = append(w.stream, 0)
+		w.count = 8
+	}
+
+	if bit {
+		w.stream[len(w.stream)-1] |= 1 << (w.count - 1)
+	}
+	w.count--
+}
+
+// writeBits 按照从高位到低位的顺序写入 u 的低 nbits 位。
+func (w *bstreamWriter) writeBits(u uint64, nbits int) {
+	for nbits > 0 {
+		nbits--
+		w.writeBit((u>>uint(nbits))&1 == 1)
+	}
+}
+
+// writeUvarint 按 7 位一组写入一个无符号整数，每组最高位表示后面是否还有字节。
+func (w *bstreamWriter) writeUvarint(u uint64) {
+	for {
+		b := byte(u & 0x7f)
+		u >>= 7
+		if u == 0 {
+			w.writeBits(uint64(b), 8)
+			return
+		}
+		w.writeBits(uint64(b|0x80), 8)
+	}
+}
+
+// writeVarint 用 zigzag 把有符号整数映射成无符号整数后再写入。
+func (w *bstreamWriter) writeVarint(v int64) {
+	w.writeUvarint(zigzagEncode(v))
+}
+
+func (w *bstreamWriter) bytes() []byte {
+	return w.stream
+}
+
+// bstreamReader 按写入顺序读取 bstreamWriter 产生的位流。
+type bstreamReader struct {
+	stream  []byte
+	byteIdx int
+	bitIdx  uint8 // 当前字节里下一个要读的位，从高位数，取值 0-7
+}
+
+func newBStreamReader(stream []byte) *bstreamReader {
+	return &bstreamReader{stream: stream}
+}
+
+// readBit 读取一个 bit，ok 为 false 表示已经读到流末尾。
+func (r *bstreamReader) readBit() (bit bool, ok bool) {
+	if r.byteIdx >= len(r.stream) {
+		return false, false
+	}
+
+	bit = r.stream[r.byteIdx]&(1<<(7-r.bitIdx)) != 0
+	r.bitIdx++
+	if r.bitIdx == 8 {
+		r.bitIdx = 0
+		r.byteIdx++
+	}
+	return bit, true
+}
+
+// readBits 读取 nbits 位，按写入时的顺序拼成一个 uint64。
+func (r *bstreamReader) readBits(nbits int) (u uint64, ok bool) {
+	for i := 0; i < nbits; i++ {
+		bit, ok := r.readBit()
+		if !ok {
+			return 0, false
+		}
+		u <<= 1
+		if bit {
+			u |= 1
+		}
+	}
+	return u, true
+}
+
+func (r *bstreamReader) readUvarint() (uint64, bool) {
+	var u uint64
+	var shift uint
+	for {
+		b, ok := r.readBits(8)
+		if !ok {
+			return 0, false
+		}
+		u |= (b & 0x7f) << shift
+		if b&0x80 == 0 {
+			return u, true
+		}
+		shift += 7
+	}
+}
+
+func (r *bstreamReader) readVarint() (int64, bool) {
+	u, ok := r.readUvarint()
+	if !ok {
+		return 0, false
+	}
+	return zigzagDecode(u), true
+}
+
+func zigzagEncode(v int64) uint64 {
+	return uint64((v << 1) ^ (v >> 63))
+}
+
+func zigzagDecode(u uint64) int64 {
+	return int64(u>>1) ^ -int64(u&1)
+}
+
+// decodeSigned 把一段 nbits 位的编码还原成 int64。writeDod 为每个 nbits
+// 桶打包的是非对称区间（比如 7 位桶是 [-63,64]，正数比负数多一个），所以
+// 桶里最大的正数值（这里是 64）本身就用最高位是 1 的位模式表示，不能按标准
+// 补码那样只要最高位是 1 就当成负数——这里只有严格大于 1<<(nbits-1) 的值才是
+// 负数的回绕，等于 1<<(nbits-1) 的那个值仍然是桶内最大的正数。
+func decodeSigned(u uint64, nbits int) int64 {
+	v := int64(u)
+	if v > 1<<(nbits-1) {
+		v -= 1 << nbits
+	}
+	return v
+}
@@ -0,0 +1,233 @@
+package tsdb
+
+// chunkSize 是一个可变 head chunk 最多容纳的样本数，写满后被封存为不可变的
+// 压缩 chunk。
+const chunkSize = 120
+
+// Chunk 是一段样本的容器。一个 entry 由若干已经封存的不可变 Chunk 加一个仍在
+// 接收写入的 head chunk 组成；写满 chunkSize 个样本后 head 被封存，entry 再
+// 开一个新的 head。
+type Chunk[T any] interface {
+	// Append 写入一个样本，调用方需要保证不会对已经封存的 chunk 再写入。
+	Append(v Value[T])
+	// Iterator 返回一个按写入顺序遍历该 chunk 样本的迭代器。
+	Iterator() ChunkIterator[T]
+	// MinTime/MaxTime 是这个 chunk 里全部样本的真实最小/最大时间戳，跟样本
+	// 写入的先后顺序无关——调用方不能假设 chunk 内部是按时间排序的。
+	MinTime() int64
+	MaxTime() int64
+}
+
+// ChunkIterator 按写入顺序遍历一个 Chunk 里的样本。
+type ChunkIterator[T any] interface {
+	Next() bool
+	At() Value[T]
+}
+
+// newChunk 按 T 的具体类型选择压缩方案：float64 用 Gorilla 异或编码，int64 用
+// delta-of-delta 编码，其余类型退化为原来的裸切片存储。
+func newChunk[T any]() Chunk[T] {
+	var zero T
+	switch any(zero).(type) {
+	case float64:
+		return newFloatChunk[T]()
+	case int64:
+		return newIntChunk[T]()
+	default:
+		return newSliceChunk[T]()
+	}
+}
+
+// sliceChunk 是非数值类型（或任何未做专门压缩的 T）的退路实现，行为等同于
+// entry 原来的裸 []Value[T] 存储。
+type sliceChunk[T any] struct {
+	values  []Value[T]
+	minTime int64
+	maxTime int64
+}
+
+func newSliceChunk[T any]() *sliceChunk[T] {
+	return &sliceChunk[T]{}
+}
+
+func (c *sliceChunk[T]) Append(v Value[T]) {
+	if len(c.values) == 0 || v.UnixNano < c.minTime {
+		c.minTime = v.UnixNano
+	}
+	if len(c.values) == 0 || v.UnixNano > c.maxTime {
+		c.maxTime = v.UnixNano
+	}
+	c.values = append(c.values, v)
+}
+
+func (c *sliceChunk[T]) MinTime() int64 { return c.minTime }
+func (c *sliceChunk[T]) MaxTime() int64 { return c.maxTime }
+
+func (c *sliceChunk[T]) Iterator() ChunkIterator[T] {
+	return &sliceChunkIterator[T]{values: c.values, idx: -1}
+}
+
+type sliceChunkIterator[T any] struct {
+	values []Value[T]
+	idx    int
+}
+
+func (it *sliceChunkIterator[T]) Next() bool {
+	it.idx++
+	return it.idx < len(it.values)
+}
+
+func (it *sliceChunkIterator[T]) At() Value[T] {
+	return it.values[it.idx]
+}
+
+// floatChunk 把时间戳压缩成 delta-of-delta 编码，样本值压缩成 Gorilla 异或编码，
+// 只在 T 真的是 float64 时由 newChunk 构造。
+type floatChunk[T any] struct {
+	tbw *bstreamWriter
+	vbw *bstreamWriter
+	tw  *dodWriter
+	vw  *xorWriter
+
+	n       int
+	minTime int64
+	maxTime int64
+}
+
+func newFloatChunk[T any]() *floatChunk[T] {
+	tbw, vbw := newBStreamWriter(), newBStreamWriter()
+	return &floatChunk[T]{
+		tbw: tbw,
+		vbw: vbw,
+		tw:  newDodWriter(tbw),
+		vw:  newXorWriter(vbw),
+	}
+}
+
+func (c *floatChunk[T]) Append(v Value[T]) {
+	if c.n == 0 || v.UnixNano < c.minTime {
+		c.minTime = v.UnixNano
+	}
+	if c.n == 0 || v.UnixNano > c.maxTime {
+		c.maxTime = v.UnixNano
+	}
+
+	c.tw.write(v.UnixNano)
+	c.vw.write(any(v.V).(float64))
+	c.n++
+}
+
+func (c *floatChunk[T]) MinTime() int64 { return c.minTime }
+func (c *floatChunk[T]) MaxTime() int64 { return c.maxTime }
+
+func (c *floatChunk[T]) Iterator() ChunkIterator[T] {
+	return &floatChunkIterator[T]{
+		tr: newDodReader(newBStreamReader(c.tbw.bytes())),
+		vr: newXorReader(newBStreamReader(c.vbw.bytes())),
+		n:  c.n,
+	}
+}
+
+type floatChunkIterator[T any] struct {
+	tr *dodReader
+	vr *xorReader
+	n  int // 这个 chunk 里真正写入的样本数，读满之后就停，不依赖位流末尾的
+	// padding bit——最后一个字节里补的 0 跟合法的 dod==0 编码没法区分
+	read int
+	cur  Value[T]
+}
+
+func (it *floatChunkIterator[T]) Next() bool {
+	if it.read >= it.n {
+		return false
+	}
+
+	t, ok := it.tr.next()
+	if !ok {
+		return false
+	}
+	v, ok := it.vr.next()
+	if !ok {
+		return false
+	}
+	it.cur = Value[T]{UnixNano: t, V: any(v).(T)}
+	it.read++
+	return true
+}
+
+func (it *floatChunkIterator[T]) At() Value[T] { return it.cur }
+
+// intChunk 对时间戳和样本值都用 delta-of-delta 编码，只在 T 真的是 int64 时由
+// newChunk 构造。
+type intChunk[T any] struct {
+	tbw *bstreamWriter
+	vbw *bstreamWriter
+	tw  *dodWriter
+	vw  *dodWriter
+
+	n       int
+	minTime int64
+	maxTime int64
+}
+
+func newIntChunk[T any]() *intChunk[T] {
+	tbw, vbw := newBStreamWriter(), newBStreamWriter()
+	return &intChunk[T]{
+		tbw: tbw,
+		vbw: vbw,
+		tw:  newDodWriter(tbw),
+		vw:  newDodWriter(vbw),
+	}
+}
+
+func (c *intChunk[T]) Append(v Value[T]) {
+	if c.n == 0 || v.UnixNano < c.minTime {
+		c.minTime = v.UnixNano
+	}
+	if c.n == 0 || v.UnixNano > c.maxTime {
+		c.maxTime = v.UnixNano
+	}
+
+	c.tw.write(v.UnixNano)
+	c.vw.write(any(v.V).(int64))
+	c.n++
+}
+
+func (c *intChunk[T]) MinTime() int64 { return c.minTime }
+func (c *intChunk[T]) MaxTime() int64 { return c.maxTime }
+
+func (c *intChunk[T]) Iterator() ChunkIterator[T] {
+	return &intChunkIterator[T]{
+		tr: newDodReader(newBStreamReader(c.tbw.bytes())),
+		vr: newDodReader(newBStreamReader(c.vbw.bytes())),
+		n:  c.n,
+	}
+}
+
+type intChunkIterator[T any] struct {
+	tr   *dodReader
+	vr   *dodReader
+	n    int // 含义同 floatChunkIterator.n
+	read int
+	cur  Value[T]
+}
+
+func (it *intChunkIterator[T]) Next() bool {
+	if it.read >= it.n {
+		return false
+	}
+
+	t, ok := it.tr.next()
+	if !ok {
+		return false
+	}
+	v, ok := it.vr.next()
+	if !ok {
+		return false
+	}
+	it.cur = Value[T]{UnixNano: t, V: any(v).(T)}
+	it.read++
+	return true
+}
+
+func (it *intChunkIterator[T]) At() Value[T] { return it.cur }
@@ -0,0 +1,75 @@
+package tsdb
+
+import "testing"
+
+func TestFloatChunkRoundTrip(t *testing.T) {
+	c := newChunk[float64]()
+
+	in := []Value[float64]{
+		{UnixNano: 1000, V: 1.5},
+		{UnixNano: 1010, V: 1.5},
+		{UnixNano: 1030, V: -2.25},
+		{UnixNano: 900, V: 3.125}, // 乱序写入一个更早的时间戳
+	}
+	for _, v := range in {
+		c.Append(v)
+	}
+
+	if got, want := c.MinTime(), int64(900); got != want {
+		t.Fatalf("MinTime() = %d, want %d", got, want)
+	}
+	if got, want := c.MaxTime(), int64(1030); got != want {
+		t.Fatalf("MaxTime() = %d, want %d", got, want)
+	}
+
+	it := c.Iterator()
+	for i, want := range in {
+		if !it.Next() {
+			t.Fatalf("iterator ended early at index %d", i)
+		}
+		if got := it.At(); got != want {
+			t.Fatalf("value %d = %+v, want %+v", i, got, want)
+		}
+	}
+	if it.Next() {
+		t.Fatalf("iterator yielded more values than written")
+	}
+}
+
+func TestIntChunkRoundTrip(t *testing.T) {
+	c := newChunk[int64]()
+
+	in := []Value[int64]{
+		{UnixNano: 5, V: 100},
+		{UnixNano: 6, V: 90},
+		{UnixNano: 20, V: -5},
+	}
+	for _, v := range in {
+		c.Append(v)
+	}
+
+	it := c.Iterator()
+	for i, want := range in {
+		if !it.Next() {
+			t.Fatalf("iterator ended early at index %d", i)
+		}
+		if got := it.At(); got != want {
+			t.Fatalf("value %d = %+v, want %+v", i, got, want)
+		}
+	}
+}
+
+func TestSliceChunkMinMaxTracksOutOfOrderWrites(t *testing.T) {
+	c := newChunk[string]()
+
+	c.Append(Value[string]{UnixNano: 100, V: "a"})
+	c.Append(Value[string]{UnixNano: 50, V: "b"})
+	c.Append(Value[string]{UnixNano: 200, V: "c"})
+
+	if got, want := c.MinTime(), int64(50); got != want {
+		t.Fatalf("MinTime() = %d, want %d", got, want)
+	}
+	if got, want := c.MaxTime(), int64(200); got != want {
+		t.Fatalf("MaxTime() = %d, want %d", got, want)
+	}
+}
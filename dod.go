@@ -0,0 +1,145 @@
+package tsdb
+
+// dodWriter 用 delta-of-delta 编码写入一串递增的 unix 纳秒时间戳：第一个时间戳
+// 原样写入，第一个差值写成 varint，此后每个样本写入
+// dod = (t_i - t_i-1) - (t_i-1 - t_i-2) 的变长位编码，这是 Gorilla 论文里时间戳
+// 压缩方案的做法，int 类型的样本值也复用同一套编码。
+type dodWriter struct {
+	bw *bstreamWriter
+
+	n      int
+	t      int64
+	tDelta int64
+}
+
+func newDodWriter(bw *bstreamWriter) *dodWriter {
+	return &dodWriter{bw: bw}
+}
+
+func (w *dodWriter) write(t int64) {
+	switch w.n {
+	case 0:
+		w.bw.writeBits(uint64(t), 64)
+	case 1:
+		w.tDelta = t - w.t
+		w.bw.writeVarint(w.tDelta)
+	default:
+		delta := t - w.t
+		writeDod(w.bw, delta-w.tDelta)
+		w.tDelta = delta
+	}
+	w.t = t
+	w.n++
+}
+
+// writeDod 按 Gorilla 的变长前缀编码写入一个 dod 值：0 用单个 0 bit 表示，否则
+// 按值所在的区间选用 2/3/4 bit 前缀加定长载荷，超出范围的用 4 bit 前缀加 32 bit
+// 原始值兜底。
+func writeDod(bw *bstreamWriter, dod int64) {
+	switch {
+	case dod == 0:
+		bw.writeBit(false)
+	case -63 <= dod && dod <= 64:
+		bw.writeBits(0b10, 2)
+		bw.writeBits(uint64(dod)&(1<<7-1), 7)
+	case -255 <= dod && dod <= 256:
+		bw.writeBits(0b110, 3)
+		bw.writeBits(uint64(dod)&(1<<9-1), 9)
+	case -2047 <= dod && dod <= 2048:
+		bw.writeBits(0b1110, 4)
+		bw.writeBits(uint64(dod)&(1<<12-1), 12)
+	default:
+		bw.writeBits(0b1111, 4)
+		bw.writeBits(uint64(uint32(dod)), 32)
+	}
+}
+
+// dodReader 读取 dodWriter 产生的时间戳（或整型样本值）序列。
+type dodReader struct {
+	br *bstreamReader
+
+	n      int
+	t      int64
+	tDelta int64
+}
+
+func newDodReader(br *bstreamReader) *dodReader {
+	return &dodReader{br: br}
+}
+
+// next 返回下一个值，ok 为 false 表示流已经耗尽。
+func (r *dodReader) next() (int64, bool) {
+	switch r.n {
+	case 0:
+		v, ok := r.br.readBits(64)
+		if !ok {
+			return 0, false
+		}
+		r.t = int64(v)
+	case 1:
+		delta, ok := r.br.readVarint()
+		if !ok {
+			return 0, false
+		}
+		r.tDelta = delta
+		r.t += delta
+	default:
+		dod, ok := readDod(r.br)
+		if !ok {
+			return 0, false
+		}
+		r.tDelta += dod
+		r.t += r.tDelta
+	}
+	r.n++
+	return r.t, true
+}
+
+func readDod(br *bstreamReader) (int64, bool) {
+	bit, ok := br.readBit()
+	if !ok || !bit {
+		return 0, ok
+	}
+
+	bit, ok = br.readBit()
+	if !ok {
+		return 0, false
+	}
+	if !bit {
+		v, ok := br.readBits(7)
+		if !ok {
+			return 0, false
+		}
+		return decodeSigned(v, 7), true
+	}
+
+	bit, ok = br.readBit()
+	if !ok {
+		return 0, false
+	}
+	if !bit {
+		v, ok := br.readBits(9)
+		if !ok {
+			return 0, false
+		}
+		return decodeSigned(v, 9), true
+	}
+
+	bit, ok = br.readBit()
+	if !ok {
+		return 0, false
+	}
+	if !bit {
+		v, ok := br.readBits(12)
+		if !ok {
+			return 0, false
+		}
+		return decodeSigned(v, 12), true
+	}
+
+	v, ok := br.readBits(32)
+	if !ok {
+		return 0, false
+	}
+	return int64(int32(uint32(v))), true
+}
@@ -0,0 +1,51 @@
+package tsdb
+
+import "testing"
+
+// TestDodRoundTripAtBucketBoundaries 覆盖每个 dod 桶里最大的正数值——
+// writeDod 打包的是非对称区间（比如 7 位桶是 [-63,64]），decodeSigned 曾经
+// 把这些值错误地当成回绕后的负数解出来
+func TestDodRoundTripAtBucketBoundaries(t *testing.T) {
+	for _, dod := range []int64{64, 256, 2048} {
+		bw := newBStreamWriter()
+		writeDod(bw, dod)
+
+		br := newBStreamReader(bw.bytes())
+		got, ok := readDod(br)
+		if !ok {
+			t.Fatalf("readDod(%d): stream ended early", dod)
+		}
+		if got != dod {
+			t.Fatalf("readDod(%d) = %d, want %d", dod, got, dod)
+		}
+	}
+}
+
+func TestDodWriterReaderRoundTrip(t *testing.T) {
+	base := int64(3000)
+	deltas := []int64{0, 10, 64, -63, 256, -255, 2048, -2047, 1 << 20, -(1 << 20)}
+
+	bw := newBStreamWriter()
+	w := newDodWriter(bw)
+
+	t1 := base
+	w.write(t1)
+	values := []int64{t1}
+	for _, d := range deltas {
+		t1 += d
+		w.write(t1)
+		values = append(values, t1)
+	}
+
+	br := newBStreamReader(bw.bytes())
+	r := newDodReader(br)
+	for i, want := range values {
+		got, ok := r.next()
+		if !ok {
+			t.Fatalf("value %d: stream ended early", i)
+		}
+		if got != want {
+			t.Fatalf("value %d = %d, want %d", i, got, want)
+		}
+	}
+}
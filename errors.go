@@ -0,0 +1,10 @@
+package tsdb
+
+import "errors"
+
+var (
+	// ErrDBClosed 表示在 DB 已经 Stop 之后还调用了写入接口
+	ErrDBClosed = errors.New("tsdb: db is closed")
+	// ErrPointMissingTag 表示写入的 Point 没有任何 tag，无法确定它所属的系列
+	ErrPointMissingTag = errors.New("tsdb: point is missing tags")
+)
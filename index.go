@@ -0,0 +1,225 @@
+package tsdb
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+)
+
+// seriesMeta 记录一个系列的 key 和它的 tag 集合
+type seriesMeta struct {
+	key  string
+	tags []Tag
+}
+
+// index 维护系列 key 到 tag 集合的映射，并给每个系列分配一个自增的数字 ID。
+// 同时为每个 label 维护一份倒排索引（postings），每个 label value 下的
+// postings 列表都按 ID 升序排列、不含重复 ID（由 indexPostings 按序插入保证，
+// WAL 回放时 ID 不一定按升序到达），支持 Query 里的 sort-merge 交集/并集
+type index struct {
+	mu sync.RWMutex
+
+	nextID int
+	ids    map[string]int     // series key -> series id
+	series map[int]seriesMeta // series id -> 元信息
+
+	postings      map[string]map[string][]int // label name -> label value -> postings
+	labelPostings map[string][]int            // label name -> 不管取值，出现过这个 label 的全部 postings
+
+	regexes *regexCache
+}
+
+func newIndex() *index {
+	return &index{
+		ids:           make(map[string]int),
+		series:        make(map[int]seriesMeta),
+		postings:      make(map[string]map[string][]int),
+		labelPostings: make(map[string][]int),
+		regexes:       newRegexCache(),
+	}
+}
+
+// createSeriesIfNotExists 为还没见过的系列分配 ID、记录它的 tag 并更新
+// postings，返回这次新分配的 key -> id，已经存在的系列不受影响
+func (idx *index) createSeriesIfNotExists(seriesTags map[string][]Tag) map[string]int {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	created := make(map[string]int)
+	for key, tags := range seriesTags {
+		if _, ok := idx.ids[key]; ok {
+			continue
+		}
+
+		id := idx.nextID
+		idx.nextID++
+		idx.ids[key] = id
+		idx.series[id] = seriesMeta{key: key, tags: tags}
+		created[key] = id
+
+		idx.indexPostings(id, tags)
+	}
+	return created
+}
+
+// restoreSeries 在 WAL 回放时重建一个系列，沿用它原来的 ID
+func (idx *index) restoreSeries(id int, tags []Tag) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	key := seriesKey(tags)
+	idx.ids[key] = id
+	idx.series[id] = seriesMeta{key: key, tags: tags}
+	if id >= idx.nextID {
+		idx.nextID = id + 1
+	}
+
+	idx.indexPostings(id, tags)
+}
+
+// indexPostings 把一个系列的每个 tag 按 ID 有序插入对应 label 的倒排索引，
+// 已经存在的 ID 不会重复插入，调用方需要持有 idx.mu 的写锁
+func (idx *index) indexPostings(id int, tags []Tag) {
+	insert := func(list []int) []int {
+		i := sort.SearchInts(list, id)
+		if i < len(list) && list[i] == id {
+			return list
+		}
+		list = append(list, 0)
+		copy(list[i+1:], list[i:])
+		list[i] = id
+		return list
+	}
+	for _, t := range tags {
+		if idx.postings[t.Key] == nil {
+			idx.postings[t.Key] = make(map[string][]int)
+		}
+		idx.postings[t.Key][t.Value] = insert(idx.postings[t.Key][t.Value])
+		idx.labelPostings[t.Key] = insert(idx.labelPostings[t.Key])
+	}
+}
+
+// seriesID 返回一个系列 key 对应的数字 ID，ok 为 false 表示系列不存在
+func (idx *index) seriesID(key string) (int, bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	id, ok := idx.ids[key]
+	return id, ok
+}
+
+// seriesKey 返回一个系列 ID 对应的 key，ok 为 false 表示 ID 不存在
+func (idx *index) seriesKey(id int) (string, bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	meta, ok := idx.series[id]
+	return meta.key, ok
+}
+
+// seriesTags 返回一个系列 ID 对应的 tag 集合，ok 为 false 表示 ID 不存在
+func (idx *index) seriesTags(id int) ([]Tag, bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	meta, ok := idx.series[id]
+	return meta.tags, ok
+}
+
+// forEach 遍历 index 里记录的所有系列
+func (idx *index) forEach(fn func(id int, key string, tags []Tag)) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	for id, meta := range idx.series {
+		fn(id, meta.key, meta.tags)
+	}
+}
+
+// postingsForMatchers 把一组 LabelMatcher 按 AND 语义组合成一个 Postings
+func (idx *index) postingsForMatchers(matchers []LabelMatcher) (Postings, error) {
+	if len(matchers) == 0 {
+		return emptyPostings{}, nil
+	}
+
+	subs := make([]Postings, 0, len(matchers))
+	for _, m := range matchers {
+		p, err := idx.postingsForMatcher(m)
+		if err != nil {
+			return nil, err
+		}
+		subs = append(subs, p)
+	}
+
+	return newIntersectPostings(subs...), nil
+}
+
+// postingsForMatcher 把单个 LabelMatcher 翻译成一个按 ID 升序排列的 Postings
+func (idx *index) postingsForMatcher(m LabelMatcher) (Postings, error) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	switch m.Type {
+	case MatchEqual:
+		return newListPostings(idx.postings[m.Name][m.Value]), nil
+	case MatchNotEqual:
+		eq := newListPostings(idx.postings[m.Name][m.Value])
+		all := newListPostings(idx.labelPostings[m.Name])
+		return newComplementPostings(all, eq), nil
+	case MatchRegex:
+		re, err := idx.regexes.compile(m.Value)
+		if err != nil {
+			return nil, err
+		}
+		return idx.postingsForRegexLocked(m.Name, re), nil
+	case MatchNotRegex:
+		re, err := idx.regexes.compile(m.Value)
+		if err != nil {
+			return nil, err
+		}
+		matched := idx.postingsForRegexLocked(m.Name, re)
+		all := newListPostings(idx.labelPostings[m.Name])
+		return newComplementPostings(all, matched), nil
+	default:
+		return nil, fmt.Errorf("tsdb: unknown match type %d", m.Type)
+	}
+}
+
+// postingsForRegexLocked 遍历一个 label 下所有取值，把匹配正则的值各自的
+// postings 合并成一个 Postings，调用方需要持有 idx.mu 的读锁
+func (idx *index) postingsForRegexLocked(name string, re *regexp.Regexp) Postings {
+	var subs []Postings
+	for value, ids := range idx.postings[name] {
+		if re.MatchString(value) {
+			subs = append(subs, newListPostings(ids))
+		}
+	}
+	return newUnionPostings(subs...)
+}
+
+// regexCache 缓存编译好的正则，同一个 pattern 在多次 Query 之间只编译一次
+type regexCache struct {
+	mu    sync.Mutex
+	cache map[string]*regexp.Regexp
+}
+
+func newRegexCache() *regexCache {
+	return &regexCache{cache: make(map[string]*regexp.Regexp)}
+}
+
+func (c *regexCache) compile(pattern string) (*regexp.Regexp, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if re, ok := c.cache[pattern]; ok {
+		return re, nil
+	}
+
+	re, err := regexp.Compile("^(?:" + pattern + ")$")
+	if err != nil {
+		return nil, err
+	}
+	c.cache[pattern] = re
+	return re, nil
+}
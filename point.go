@@ -0,0 +1,54 @@
+package tsdb
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// Tag 是一个 key-value 标签，用来标识一个系列（series）
+type Tag struct {
+	Key   string
+	Value string
+}
+
+// Point 是一次写入的原始数据：一组 tag 加上一个时间点的值
+type Point[T any] struct {
+	tags  []Tag
+	time  time.Time
+	field T
+}
+
+// NewPoint 构建一个 Point
+func NewPoint[T any](tags []Tag, t time.Time, field T) Point[T] {
+	return Point[T]{tags: tags, time: t, field: field}
+}
+
+// Series 把 tag 按 key 排序后拼成这个 Point 所属系列的唯一 key，没有 tag 的
+// Point 不属于任何系列，返回空字符串
+func (p Point[T]) Series() string {
+	return seriesKey(p.tags)
+}
+
+// seriesKey 把一组 tag 按 key 排序后拼成系列的唯一 key，tag 顺序无关，相同的
+// tag 集合总是产生相同的 key
+func seriesKey(tags []Tag) string {
+	if len(tags) == 0 {
+		return ""
+	}
+
+	sorted := make([]Tag, len(tags))
+	copy(sorted, tags)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Key < sorted[j].Key })
+
+	var b strings.Builder
+	for i, t := range sorted {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(t.Key)
+		b.WriteByte('=')
+		b.WriteString(t.Value)
+	}
+	return b.String()
+}
@@ -0,0 +1,192 @@
+package tsdb
+
+import "container/heap"
+
+// Postings 是一串按 ID 升序排列的系列 ID，交集/并集/补集都基于它组合，避免
+// 为中间结果分配大切片
+type Postings interface {
+	Next() bool
+	At() int
+	Err() error
+}
+
+// emptyPostings 是一个总是为空的 Postings
+type emptyPostings struct{}
+
+func (emptyPostings) Next() bool { return false }
+func (emptyPostings) At() int    { return 0 }
+func (emptyPostings) Err() error { return nil }
+
+// listPostings 把一个已经按 ID 升序排列的切片包装成 Postings
+type listPostings struct {
+	list []int
+	idx  int
+}
+
+func newListPostings(list []int) *listPostings {
+	return &listPostings{list: list, idx: -1}
+}
+
+func (p *listPostings) Next() bool {
+	p.idx++
+	return p.idx < len(p.list)
+}
+
+func (p *listPostings) At() int    { return p.list[p.idx] }
+func (p *listPostings) Err() error { return nil }
+
+// intersectPostings 对多个已经按 ID 升序排列的 Postings 做交集，用经典的
+// sort-merge 游走实现：每次 Next 先把所有子迭代器都往前挪一格，再反复把落后
+// 的子迭代器追到当前最大值，直到所有子迭代器都指向同一个 ID
+type intersectPostings struct {
+	subs []Postings
+	cur  int
+}
+
+func newIntersectPostings(subs ...Postings) Postings {
+	switch len(subs) {
+	case 0:
+		return emptyPostings{}
+	case 1:
+		return subs[0]
+	default:
+		return &intersectPostings{subs: subs}
+	}
+}
+
+func (p *intersectPostings) Next() bool {
+	for _, s := range p.subs {
+		if !s.Next() {
+			return false
+		}
+	}
+
+	for {
+		maxID := p.subs[0].At()
+		allEqual := true
+		for _, s := range p.subs[1:] {
+			if s.At() != maxID {
+				allEqual = false
+			}
+			if s.At() > maxID {
+				maxID = s.At()
+			}
+		}
+		if allEqual {
+			p.cur = maxID
+			return true
+		}
+
+		for _, s := range p.subs {
+			for s.At() < maxID {
+				if !s.Next() {
+					return false
+				}
+			}
+		}
+	}
+}
+
+func (p *intersectPostings) At() int { return p.cur }
+
+func (p *intersectPostings) Err() error {
+	for _, s := range p.subs {
+		if err := s.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unionPostings 对多个已经按 ID 升序排列的 Postings 做并集（去重），用小顶堆
+// 做多路归并，这是用来合并一个 regex matcher 命中的多个 label value 各自的
+// postings 的
+type unionPostings struct {
+	h   postingsHeap
+	cur int
+}
+
+func newUnionPostings(subs ...Postings) Postings {
+	h := make(postingsHeap, 0, len(subs))
+	for _, s := range subs {
+		if s.Next() {
+			h = append(h, s)
+		}
+	}
+	heap.Init(&h)
+	return &unionPostings{h: h}
+}
+
+func (p *unionPostings) Next() bool {
+	if len(p.h) == 0 {
+		return false
+	}
+
+	p.cur = p.h[0].At()
+	for len(p.h) > 0 && p.h[0].At() == p.cur {
+		top := p.h[0]
+		if top.Next() {
+			heap.Fix(&p.h, 0)
+		} else {
+			heap.Pop(&p.h)
+		}
+	}
+	return true
+}
+
+func (p *unionPostings) At() int { return p.cur }
+
+func (p *unionPostings) Err() error {
+	for _, s := range p.h {
+		if err := s.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type postingsHeap []Postings
+
+func (h postingsHeap) Len() int           { return len(h) }
+func (h postingsHeap) Less(i, j int) bool { return h[i].At() < h[j].At() }
+func (h postingsHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *postingsHeap) Push(x any) { *h = append(*h, x.(Postings)) }
+
+func (h *postingsHeap) Pop() any {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	*h = old[:n-1]
+	return item
+}
+
+// complementPostings 返回 base 里存在、但 exclude 里不存在的 ID，用来实现否定
+// matcher（对某个 label 的"全部 postings"取补集）。exclude 先被物化成一个
+// set——对否定匹配而言它通常远小于整个系列集合，换取实现的简单性
+type complementPostings struct {
+	base    Postings
+	exclude map[int]struct{}
+	cur     int
+}
+
+func newComplementPostings(base, exclude Postings) Postings {
+	excluded := make(map[int]struct{})
+	for exclude.Next() {
+		excluded[exclude.At()] = struct{}{}
+	}
+	return &complementPostings{base: base, exclude: excluded}
+}
+
+func (p *complementPostings) Next() bool {
+	for p.base.Next() {
+		if _, ok := p.exclude[p.base.At()]; !ok {
+			p.cur = p.base.At()
+			return true
+		}
+	}
+	return false
+}
+
+func (p *complementPostings) At() int    { return p.cur }
+func (p *complementPostings) Err() error { return p.base.Err() }
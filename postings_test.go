@@ -0,0 +1,74 @@
+package tsdb
+
+import "testing"
+
+func drainPostings(t *testing.T, p Postings) []int {
+	t.Helper()
+
+	var got []int
+	for p.Next() {
+		got = append(got, p.At())
+	}
+	if err := p.Err(); err != nil {
+		t.Fatalf("Err(): %v", err)
+	}
+	return got
+}
+
+func assertPostings(t *testing.T, p Postings, want []int) {
+	t.Helper()
+
+	got := drainPostings(t, p)
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+}
+
+func TestListPostings(t *testing.T) {
+	assertPostings(t, newListPostings([]int{1, 2, 5}), []int{1, 2, 5})
+	assertPostings(t, newListPostings(nil), nil)
+}
+
+func TestIntersectPostings(t *testing.T) {
+	a := newListPostings([]int{1, 2, 3, 5, 8})
+	b := newListPostings([]int{2, 3, 4, 8})
+	c := newListPostings([]int{2, 8, 9})
+
+	assertPostings(t, newIntersectPostings(a, b, c), []int{2, 8})
+}
+
+func TestIntersectPostingsEmptyWhenNoOverlap(t *testing.T) {
+	a := newListPostings([]int{1, 2})
+	b := newListPostings([]int{3, 4})
+
+	assertPostings(t, newIntersectPostings(a, b), nil)
+}
+
+func TestIntersectPostingsSingleSubReturnsItUnchanged(t *testing.T) {
+	a := newListPostings([]int{1, 2, 3})
+
+	assertPostings(t, newIntersectPostings(a), []int{1, 2, 3})
+}
+
+func TestIntersectPostingsNoSubsIsEmpty(t *testing.T) {
+	assertPostings(t, newIntersectPostings(), nil)
+}
+
+func TestUnionPostingsDedupsAndSorts(t *testing.T) {
+	a := newListPostings([]int{1, 3, 5})
+	b := newListPostings([]int{2, 3, 6})
+
+	assertPostings(t, newUnionPostings(a, b), []int{1, 2, 3, 5, 6})
+}
+
+func TestComplementPostings(t *testing.T) {
+	base := newListPostings([]int{1, 2, 3, 4, 5})
+	exclude := newListPostings([]int{2, 4})
+
+	assertPostings(t, newComplementPostings(base, exclude), []int{1, 3, 5})
+}
@@ -0,0 +1,123 @@
+package tsdb
+
+import (
+	"sort"
+	"time"
+)
+
+// MatchType 描述 LabelMatcher 用什么方式匹配一个 label 的取值
+type MatchType int
+
+const (
+	MatchEqual MatchType = iota
+	MatchNotEqual
+	MatchRegex
+	MatchNotRegex
+)
+
+// LabelMatcher 描述对一个 label 的匹配条件，多个 matcher 之间按 AND 组合，
+// 模仿 Prometheus 的 matcher 语义
+type LabelMatcher struct {
+	Name  string
+	Value string
+	Type  MatchType
+}
+
+// Series 是 Query 的一条结果：一个系列的 key、它的 tag，以及命中时间窗口内
+// 的样本
+type Series[T any] struct {
+	Key    string
+	Tags   []Tag
+	Values []Value[T]
+}
+
+// querySeries 对一份 idx+store 执行一次 label 匹配查询，返回命中的系列 key、
+// tag 和窗口内的样本。TSDB.Query 用它分别查当前 head 和每个时间窗口有交集的
+// block，再把结果按系列 key 合并起来
+func querySeries[T any](idx *index, store *shard[T], matchers []LabelMatcher, min, max int64) ([]Series[T], error) {
+	postings, err := idx.postingsForMatchers(matchers)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []Series[T]
+	for postings.Next() {
+		id := postings.At()
+
+		key, ok := idx.seriesKey(id)
+		if !ok {
+			continue
+		}
+
+		values := store.valuesBetween(key, min, max)
+		if len(values) == 0 {
+			continue
+		}
+
+		tags, _ := idx.seriesTags(id)
+		result = append(result, Series[T]{Key: key, Tags: tags, Values: values})
+	}
+	if err := postings.Err(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Query 返回同时满足全部 matchers、且在 [min, max] 窗口内有样本的系列，例如
+// {region="SH", host=~"web-.*"}。先查每个时间窗口跟 [min, max] 有交集的
+// block，再查当前 head，最后按系列 key 把各自的结果合并、按时间排序，并过滤
+// 掉被 tombstone 标记的样本
+func (db *TSDB[T]) Query(matchers []LabelMatcher, min, max time.Time) ([]Series[T], error) {
+	minNano, maxNano := min.UnixNano(), max.UnixNano()
+
+	db.headMu.RLock()
+	idx, store := db.idx, db.store
+	blocks := make([]*block[T], len(db.blocks))
+	copy(blocks, db.blocks)
+	db.headMu.RUnlock()
+
+	merged := make(map[string]*Series[T])
+	var order []string
+
+	add := func(series []Series[T]) {
+		for _, s := range series {
+			values := filterTombstoned(db.tombstones, s.Key, s.Values)
+			if len(values) == 0 {
+				continue
+			}
+
+			cur, ok := merged[s.Key]
+			if !ok {
+				cur = &Series[T]{Key: s.Key, Tags: s.Tags}
+				merged[s.Key] = cur
+				order = append(order, s.Key)
+			}
+			cur.Values = append(cur.Values, values...)
+		}
+	}
+
+	for _, b := range blocks {
+		if !b.overlaps(minNano, maxNano) {
+			continue
+		}
+		series, err := querySeries(b.idx, b.store, matchers, minNano, maxNano)
+		if err != nil {
+			return nil, err
+		}
+		add(series)
+	}
+
+	series, err := querySeries(idx, store, matchers, minNano, maxNano)
+	if err != nil {
+		return nil, err
+	}
+	add(series)
+
+	result := make([]Series[T], 0, len(order))
+	for _, key := range order {
+		s := merged[key]
+		sort.Slice(s.Values, func(i, j int) bool { return s.Values[i].UnixNano < s.Values[j].UnixNano })
+		result = append(result, *s)
+	}
+	return result, nil
+}
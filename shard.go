@@ -14,19 +14,23 @@ type Value[T any] struct {
 	V        T
 }
 
-// entry 保存 values，目的减少写入已存在系列的数据的锁争用
+// entry 保存 values，目的减少写入已存在系列的数据的锁争用。数值类型的样本会
+// 被压缩进一串不可变的 Chunk，只有最新的 head chunk 可写，写满 chunkSize 个
+// 样本就封存并换一个新的 head；非数值类型的 T 退化成原来的裸切片存储。
 type entry[T any] struct {
 	mu sync.RWMutex
 
-	values []Value[T]
+	chunks  []Chunk[T]
+	head    Chunk[T]
+	headLen int
 }
 
 // newEntry copy Value 并构建一个新的 entry
 func newEntry[T any](vs []Value[T]) *entry[T] {
-	values := make([]Value[T], 0, len(vs))
-	values = append(values, vs...)
+	e := &entry[T]{head: newChunk[T]()}
+	e.add(vs)
 
-	return &entry[T]{values: values}
+	return e
 }
 
 // add 往 entry 中写入数据
@@ -34,38 +38,144 @@ func (e *entry[T]) add(values []Value[T]) {
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
-	e.values = append(e.values, values...)
+	for _, v := range values {
+		e.head.Append(v)
+		e.headLen++
+		if e.headLen == chunkSize {
+			e.chunks = append(e.chunks, e.head)
+			e.head = newChunk[T]()
+			e.headLen = 0
+		}
+	}
 }
 
-// removeBefore 删除小于 unixNano 的数据
+// removeBefore 删除小于 unixNano 的数据。sealed chunk 不可变，没法就地删除，
+// 这里把保留下来的样本重新走一遍压缩流程。
 func (e *entry[T]) removeBefore(unixNano int64) {
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
-	values := make([]Value[T], 0, len(e.values))
-	for _, v := range e.values {
+	if e.maxTime() < unixNano {
+		e.chunks = nil
+		e.head = newChunk[T]()
+		e.headLen = 0
+		return
+	}
+
+	var kept []Value[T]
+	e.forEach(func(v Value[T]) {
 		if v.UnixNano >= unixNano {
-			values = append(values, v)
+			kept = append(kept, v)
+		}
+	})
+
+	e.chunks = nil
+	e.head = newChunk[T]()
+	e.headLen = 0
+	for _, v := range kept {
+		e.head.Append(v)
+		e.headLen++
+		if e.headLen == chunkSize {
+			e.chunks = append(e.chunks, e.head)
+			e.head = newChunk[T]()
+			e.headLen = 0
 		}
 	}
-	e.values = values
 }
 
-// valuesBetween 获取两个时间之间的 Value
+// valuesBetween 获取两个时间之间的 Value，跳过时间范围跟 [min, max] 没有交集
+// 的 sealed chunk，避免解压不可能命中的数据。写入并不保证按时间顺序到达，
+// 所以 chunks 之间（以及 chunk 内部）都不能假设是按时间排序的——这里只能
+// 对每个 chunk 单独判断要不要跳过，不能在某个 chunk 的时间范围看起来"已经
+// 超过 max"时就提前终止整个扫描，后面的 chunk 仍然可能包含更早的样本。
 func (e *entry[T]) valuesBetween(min, max int64) []Value[T] {
 	e.mu.RLock()
 	defer e.mu.RUnlock()
 
 	var values []Value[T]
-	for _, v := range e.values {
-		if v.UnixNano >= min && v.UnixNano <= max {
-			values = append(values, v)
+	for _, c := range e.chunks {
+		if c.MaxTime() < min || c.MinTime() > max {
+			continue
+		}
+
+		it := c.Iterator()
+		for it.Next() {
+			if v := it.At(); v.UnixNano >= min && v.UnixNano <= max {
+				values = append(values, v)
+			}
+		}
+	}
+
+	if e.headLen > 0 && e.head.MaxTime() >= min && e.head.MinTime() <= max {
+		it := e.head.Iterator()
+		for it.Next() {
+			if v := it.At(); v.UnixNano >= min && v.UnixNano <= max {
+				values = append(values, v)
+			}
 		}
 	}
 
 	return values
 }
 
+// forEach 按写入顺序遍历 entry 里所有的样本，先是已经封存的 chunk，再是 head，
+// 不保证按时间排序
+func (e *entry[T]) forEach(fn func(Value[T])) {
+	for _, c := range e.chunks {
+		it := c.Iterator()
+		for it.Next() {
+			fn(it.At())
+		}
+	}
+
+	it := e.head.Iterator()
+	for it.Next() {
+		fn(it.At())
+	}
+}
+
+// maxTime 返回 entry 里最新样本的时间
+func (e *entry[T]) maxTime() int64 {
+	var max int64
+	found := false
+	for _, c := range e.chunks {
+		if !found || c.MaxTime() > max {
+			max, found = c.MaxTime(), true
+		}
+	}
+	if e.headLen > 0 && (!found || e.head.MaxTime() > max) {
+		max = e.head.MaxTime()
+	}
+	return max
+}
+
+// isEmpty 判断 entry 是否已经没有任何样本
+func (e *entry[T]) isEmpty() bool {
+	return len(e.chunks) == 0 && e.headLen == 0
+}
+
+// seal 把还没写满的 head chunk 也封存进 chunks。调用方必须保证这个 entry
+// 封存之后不会再被写入，用在把整个 shard 冻结成不可变 block 的时候。
+func (e *entry[T]) seal() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.headLen > 0 {
+		e.chunks = append(e.chunks, e.head)
+		e.head = nil
+		e.headLen = 0
+	}
+}
+
+// allChunks 返回 entry 里全部已经封存的 chunk，调用方必须保证这个 entry 已经
+// seal 过，不会再发生并发写入
+func (e *entry[T]) allChunks() []Chunk[T] {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return e.chunks
+}
+
 // partition hash ring 的一个分片，目的是减少新新系列的锁争用
 type partition[T any] struct {
 	mu sync.RWMutex
@@ -111,14 +221,39 @@ func (p *partition[T]) removeBefore(unixNano int64) {
 	store := make(map[string]*entry[T], len(p.store))
 	for k, e := range p.store {
 		e.removeBefore(unixNano)
-		// cap = 0 说明上次 remove 的时候已经没有 Value ， 较大可能后续也没有 Value ，就不加入 store 了
-		if cap(e.values) != 0 {
+		// entry 已经没有样本了，较大可能后续也没有 Value ，就不加入 store 了
+		if !e.isEmpty() {
 			store[k] = e
 		}
 	}
 	p.store = store
 }
 
+// forEach 遍历 partition 里所有系列当前的全部样本
+func (p *partition[T]) forEach(fn func(key string, values []Value[T])) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	for k, e := range p.store {
+		var values []Value[T]
+		e.forEach(func(v Value[T]) { values = append(values, v) })
+		fn(k, values)
+	}
+}
+
+// rewrite 用 values 整个替换掉 key 对应系列目前的数据，没有 values 就直接把
+// 这个系列删掉；用于 tombstone compaction 之类需要物理清理数据的场景
+func (p *partition[T]) rewrite(key string, values []Value[T]) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(values) == 0 {
+		delete(p.store, key)
+		return
+	}
+	p.store[key] = newEntry(values)
+}
+
 func (p *partition[T]) valuesBetween(key string, min, max int64) []Value[T] {
 	p.mu.RLock()
 	e := p.store[key]
@@ -130,6 +265,29 @@ func (p *partition[T]) valuesBetween(key string, min, max int64) []Value[T] {
 	return e.valuesBetween(min, max)
 }
 
+// seal 把 partition 里每个系列还没写满的 head chunk 都封存掉
+func (p *partition[T]) seal() {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	for _, e := range p.store {
+		e.seal()
+	}
+}
+
+// chunks 返回 key 对应系列全部已经封存的 chunk，调用方必须保证这个 partition
+// 已经 seal 过
+func (p *partition[T]) chunks(key string) []Chunk[T] {
+	p.mu.RLock()
+	e := p.store[key]
+	p.mu.RUnlock()
+
+	if e == nil {
+		return nil
+	}
+	return e.allChunks()
+}
+
 type shard[T any] struct {
 	partitions []*partition[T]
 }
@@ -159,3 +317,32 @@ func (s *shard[T]) writeMulti(values map[string][]Value[T]) {
 		s.getPartitions(k).write(k, v)
 	}
 }
+
+// forEach 遍历 shard 里所有系列当前的全部样本，用于需要拿到全量数据的场景
+func (s *shard[T]) forEach(fn func(key string, values []Value[T])) {
+	for _, p := range s.partitions {
+		p.forEach(fn)
+	}
+}
+
+func (s *shard[T]) valuesBetween(key string, min, max int64) []Value[T] {
+	return s.getPartitions(key).valuesBetween(key, min, max)
+}
+
+func (s *shard[T]) rewrite(key string, values []Value[T]) {
+	s.getPartitions(key).rewrite(key, values)
+}
+
+// seal 把 shard 里每个系列还没写满的 head chunk 都封存掉，用在把一个活跃的
+// head 冻结成不可变 block 的时候
+func (s *shard[T]) seal() {
+	for _, p := range s.partitions {
+		p.seal()
+	}
+}
+
+// chunks 返回 key 对应系列全部已经封存的 chunk，用于 block 合并时按系列做
+// k-way 归并
+func (s *shard[T]) chunks(key string) []Chunk[T] {
+	return s.getPartitions(key).chunks(key)
+}
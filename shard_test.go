@@ -1,63 +1,104 @@
-package memtsdb
-
-import (
-	"testing"
-	"time"
-
-	"github.com/stretchr/testify/assert"
-)
-
-func TestShard_updateIndex(t *testing.T) {
-	s := NewMemShard[int]()
-
-	s.updateIndex(1, Tag{Key: "a", Value: "b"})
-	assert.Equal(t, []int{1}, s.index["a"]["b"])
-
-	s.updateIndex(2, Tag{Key: "a", Value: "b"})
-	assert.Equal(t, []int{1, 2}, s.index["a"]["b"])
-
-	s.updateIndex(3, Tag{Key: "a", Value: "c"})
-	assert.Equal(t, []int{3}, s.index["a"]["c"])
+package tsdb
+
+import "testing"
+
+func TestEntryValuesBetweenOutOfOrderAcrossChunks(t *testing.T) {
+	e := newEntry[float64](nil)
+
+	// 填满并封存第一个 chunk，时间戳都比较大
+	var first []Value[float64]
+	for i := int64(0); i < chunkSize; i++ {
+		first = append(first, Value[float64]{UnixNano: 1000 + i, V: float64(i)})
+	}
+	e.add(first)
+	if len(e.chunks) != 1 {
+		t.Fatalf("want 1 sealed chunk, got %d", len(e.chunks))
+	}
+
+	// 第二个（还没写满的）chunk 里混入一个比第一个 chunk 更早的时间戳
+	e.add([]Value[float64]{
+		{UnixNano: 10, V: 99},
+		{UnixNano: 2000, V: 100},
+	})
+
+	values := e.valuesBetween(0, 20)
+	if len(values) != 1 || values[0].UnixNano != 10 {
+		t.Fatalf("want exactly the out-of-order sample at t=10, got %+v", values)
+	}
 }
 
-func TestShard_Insert(t *testing.T) {
-	s := NewMemShard[int]()
-
-	s.Insert(Point[int]{Tags: []Tag{{Key: "a", Value: "b"}, {Key: "c", Value: "d"}}, Field: 0})
-	assert.Len(t, s.values, 1)
-	assert.Equal(t, []int{0}, s.index["a"]["b"])
-	assert.Equal(t, []int{0}, s.index["c"]["d"])
+func TestEntryAddSealsAtChunkSize(t *testing.T) {
+	e := newEntry[int64](nil)
+
+	var values []Value[int64]
+	for i := int64(0); i < chunkSize+1; i++ {
+		values = append(values, Value[int64]{UnixNano: i, V: i})
+	}
+	e.add(values)
+
+	if len(e.chunks) != 1 {
+		t.Fatalf("want 1 sealed chunk after %d samples, got %d", chunkSize+1, len(e.chunks))
+	}
+	if e.headLen != 1 {
+		t.Fatalf("want 1 sample left in head, got %d", e.headLen)
+	}
+
+	got := e.valuesBetween(0, chunkSize)
+	if len(got) != int(chunkSize)+1 {
+		t.Fatalf("want %d values back, got %d", chunkSize+1, len(got))
+	}
 }
 
-func TestShard_Query(t *testing.T) {
-	s := NewMemShard[int]()
+func TestPartitionRemoveBefore(t *testing.T) {
+	p := newPartition[float64]()
 
-	s.Insert(Point[int]{Tags: []Tag{{Key: "a", Value: "b"}}, Field: 1, Time: time.Unix(1, 0)})
-	s.Insert(Point[int]{Tags: []Tag{{Key: "a", Value: "b"}}, Field: 2, Time: time.Unix(2, 0)})
-	s.Insert(Point[int]{Tags: []Tag{{Key: "a", Value: "b"}}, Field: 3, Time: time.Unix(3, 0)})
-	s.Insert(Point[int]{Tags: []Tag{{Key: "a", Value: "b"}}, Field: 10, Time: time.Unix(10, 0)})
+	p.write("s", []Value[float64]{
+		{UnixNano: 1, V: 1},
+		{UnixNano: 2, V: 2},
+		{UnixNano: 3, V: 3},
+	})
 
-	ps := s.Query(Tag{Key: "a", Value: "b"}, time.Unix(2, 0), time.Unix(3, 0))
-	assert.Len(t, ps, 2)
-	assert.Equal(t, 2, ps[0])
-	assert.Equal(t, 3, ps[1])
+	p.removeBefore(2)
 
-	ps = s.Query(Tag{Key: "g"}, time.Unix(1, 0), time.Unix(2, 0))
-	assert.Empty(t, ps)
+	got := p.valuesBetween("s", 0, 10)
+	if len(got) != 2 || got[0].UnixNano != 2 || got[1].UnixNano != 3 {
+		t.Fatalf("want [2,3], got %+v", got)
+	}
+}
 
-	ps = s.Query(Tag{Key: "a", Value: "c"}, time.Unix(1, 0), time.Unix(2, 0))
-	assert.Empty(t, ps)
+// TestEntryAddAfterSealPanics 记录一个 entry 层面的既有约束：seal 之后
+// e.head 被置成 nil，调用方必须保证 seal 过的 entry 不会再被写入，否则
+// add 会在 nil head 上 panic。TSDB.WritePoints 就是靠一直握着 headMu 的读锁
+// 直到写完 store 才放手，来保证 rotateHead（拿写锁、调用 seal）不会在它
+// 中途把这个 entry 封存掉。
+func TestEntryAddAfterSealPanics(t *testing.T) {
+	e := newEntry[float64]([]Value[float64]{{UnixNano: 1, V: 1}})
+	e.seal()
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Fatalf("want add on a sealed entry to panic, got no panic")
+		}
+	}()
+	e.add([]Value[float64]{{UnixNano: 1, V: 1}})
 }
 
-func TestShard_Clear(t *testing.T) {
-	s := NewMemShard[int]()
+func TestShardRewrite(t *testing.T) {
+	s := newShard[int64]()
 
-	s.Insert(Point[int]{Tags: []Tag{{Key: "a", Value: "b"}}, Field: 1, Time: time.Unix(1, 0)})
-	s.Insert(Point[int]{Tags: []Tag{{Key: "a", Value: "b"}}, Field: 2, Time: time.Unix(2, 0)})
-	s.Insert(Point[int]{Tags: []Tag{{Key: "a", Value: "b"}}, Field: 3, Time: time.Unix(3, 0)})
+	s.writeMulti(map[string][]Value[int64]{
+		"s": {{UnixNano: 1, V: 1}, {UnixNano: 2, V: 2}},
+	})
 
-	s.Clear()
+	s.rewrite("s", []Value[int64]{{UnixNano: 5, V: 5}})
+	got := s.valuesBetween("s", 0, 10)
+	if len(got) != 1 || got[0].UnixNano != 5 {
+		t.Fatalf("want [5], got %+v", got)
+	}
 
-	assert.Empty(t, s.values)
-	assert.Empty(t, s.index)
+	s.rewrite("s", nil)
+	got = s.valuesBetween("s", 0, 10)
+	if len(got) != 0 {
+		t.Fatalf("want no values after rewriting with nil, got %+v", got)
+	}
 }
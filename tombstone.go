@@ -0,0 +1,124 @@
+package tsdb
+
+import (
+	"sort"
+	"sync"
+)
+
+// Interval 是一个左闭右闭的时间区间 [Mint, Maxt]，用来表示一段被标记删除的
+// 样本时间范围
+type Interval struct {
+	Mint int64
+	Maxt int64
+}
+
+// Intervals 是一组按起始时间升序排列、且互不重叠/不相邻的 Interval
+type Intervals []Interval
+
+// Add 把一个新的 Interval 合并进来，和已有的区间按 Prometheus tombstone 的
+// 做法合并：重叠或相邻的区间会被合并成一个，结果仍然按时间升序排列
+func (in Intervals) Add(iv Interval) Intervals {
+	merged := make(Intervals, 0, len(in)+1)
+	merged = append(merged, in...)
+	merged = append(merged, iv)
+
+	sort.Slice(merged, func(i, j int) bool { return merged[i].Mint < merged[j].Mint })
+
+	out := merged[:0:0]
+	for _, cur := range merged {
+		if len(out) > 0 && cur.Mint <= out[len(out)-1].Maxt+1 {
+			if cur.Maxt > out[len(out)-1].Maxt {
+				out[len(out)-1].Maxt = cur.Maxt
+			}
+			continue
+		}
+		out = append(out, cur)
+	}
+	return out
+}
+
+// InBounds 判断 t 是否落在这组区间里的某一个里面
+func (in Intervals) InBounds(t int64) bool {
+	i := sort.Search(len(in), func(i int) bool { return in[i].Maxt >= t })
+	return i < len(in) && in[i].Mint <= t
+}
+
+// tombstones 维护每个系列被标记删除的时间区间。用区间表示删除比立刻重写存储
+// 轻量得多——真正的物理清理交给周期性的 compaction
+type tombstones struct {
+	mu        sync.RWMutex
+	intervals map[string]Intervals
+}
+
+func newTombstones() *tombstones {
+	return &tombstones{intervals: make(map[string]Intervals)}
+}
+
+func (t *tombstones) add(key string, iv Interval) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.intervals[key] = t.intervals[key].Add(iv)
+}
+
+func (t *tombstones) get(key string) Intervals {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return t.intervals[key]
+}
+
+// removeBefore 丢弃所有 Maxt 早于 cutoff 的 tombstone 区间：这些区间标记的
+// 样本本来就已经被 retention 物理删除了，没必要再保留
+func (t *tombstones) removeBefore(cutoff int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for key, intervals := range t.intervals {
+		kept := intervals[:0:0]
+		for _, iv := range intervals {
+			if iv.Maxt >= cutoff {
+				kept = append(kept, iv)
+			}
+		}
+		if len(kept) == 0 {
+			delete(t.intervals, key)
+		} else {
+			t.intervals[key] = kept
+		}
+	}
+}
+
+func (t *tombstones) keys() []string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	keys := make([]string, 0, len(t.intervals))
+	for key := range t.intervals {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func (t *tombstones) delete(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.intervals, key)
+}
+
+// filterTombstoned 返回 values 里没有被 key 对应的 tombstone 区间覆盖的样本
+func filterTombstoned[T any](ts *tombstones, key string, values []Value[T]) []Value[T] {
+	intervals := ts.get(key)
+	if len(intervals) == 0 {
+		return values
+	}
+
+	out := values[:0:0]
+	for _, v := range values {
+		if !intervals.InBounds(v.UnixNano) {
+			out = append(out, v)
+		}
+	}
+	return out
+}
@@ -0,0 +1,87 @@
+package tsdb
+
+import "testing"
+
+func TestIntervalsAddMergesOverlappingAndAdjacent(t *testing.T) {
+	var in Intervals
+
+	in = in.Add(Interval{Mint: 10, Maxt: 20})
+	in = in.Add(Interval{Mint: 15, Maxt: 25}) // 重叠
+	in = in.Add(Interval{Mint: 26, Maxt: 30}) // 紧邻
+	in = in.Add(Interval{Mint: 100, Maxt: 200})
+
+	want := Intervals{{Mint: 10, Maxt: 30}, {Mint: 100, Maxt: 200}}
+	if len(in) != len(want) {
+		t.Fatalf("got %+v, want %+v", in, want)
+	}
+	for i := range want {
+		if in[i] != want[i] {
+			t.Fatalf("got %+v, want %+v", in, want)
+		}
+	}
+}
+
+func TestIntervalsInBounds(t *testing.T) {
+	var in Intervals
+	in = in.Add(Interval{Mint: 10, Maxt: 20})
+	in = in.Add(Interval{Mint: 50, Maxt: 60})
+
+	cases := []struct {
+		t    int64
+		want bool
+	}{
+		{5, false},
+		{10, true},
+		{15, true},
+		{20, true},
+		{21, false},
+		{55, true},
+		{61, false},
+	}
+	for _, c := range cases {
+		if got := in.InBounds(c.t); got != c.want {
+			t.Fatalf("InBounds(%d) = %v, want %v", c.t, got, c.want)
+		}
+	}
+}
+
+func TestTombstonesRemoveBefore(t *testing.T) {
+	ts := newTombstones()
+	ts.add("s1", Interval{Mint: 0, Maxt: 10})
+	ts.add("s2", Interval{Mint: 20, Maxt: 30})
+
+	ts.removeBefore(15)
+
+	if got := ts.get("s1"); len(got) != 0 {
+		t.Fatalf("want s1 tombstones purged, got %+v", got)
+	}
+	if got := ts.get("s2"); len(got) != 1 {
+		t.Fatalf("want s2 tombstone kept, got %+v", got)
+	}
+
+	keys := ts.keys()
+	if len(keys) != 1 || keys[0] != "s2" {
+		t.Fatalf("want only s2 left in keys, got %v", keys)
+	}
+}
+
+func TestFilterTombstoned(t *testing.T) {
+	ts := newTombstones()
+	ts.add("s1", Interval{Mint: 10, Maxt: 20})
+
+	values := []Value[int64]{
+		{UnixNano: 5, V: 1},
+		{UnixNano: 15, V: 2},
+		{UnixNano: 25, V: 3},
+	}
+
+	got := filterTombstoned(ts, "s1", values)
+	if len(got) != 2 || got[0].UnixNano != 5 || got[1].UnixNano != 25 {
+		t.Fatalf("want samples at t=5 and t=25 to survive, got %+v", got)
+	}
+
+	none := filterTombstoned(ts, "s-without-tombstones", values)
+	if len(none) != len(values) {
+		t.Fatalf("want values untouched when no tombstones exist, got %+v", none)
+	}
+}
@@ -1,45 +1,132 @@
 package tsdb
 
 import (
+	"math"
+	"sync"
 	"time"
 )
 
+// tombstonePurgeThreshold 是一个系列被 tombstone 覆盖的样本占比超过这个值时，
+// compaction 就会把它物理清理掉，而不是一直靠 valuesBetween 过滤
+const tombstonePurgeThreshold = 0.25
+
+// TSDB[T] 的可写部分只有 store/idx 这个当前的 "head"，时间跨度被限制在
+// defaultHeadWindow 之内；超出窗口的数据会被整个封存成一个只读的 block 追加
+// 到 blocks 里。查询的时候两边都要看，写入只会落到 head。headMu 保护的是
+// idx/store/window/blocks 这几个字段本身的替换，不是它们内部的数据——那些
+// 各自有自己的锁
 type TSDB[T any] struct {
 	retentionPolicy time.Duration
 
 	stop     chan struct{}
 	isClosed bool
 
-	idx   *index
-	store *shard[T]
+	headMu sync.RWMutex
+	idx    *index
+	store  *shard[T]
+	window *headBounds
+	blocks []*block[T] // 按 minTime 升序排列的只读 block
+
+	wal        *wal[T]
+	tombstones *tombstones
 }
 
+// New 创建一个纯内存的 TSDB，不落 WAL，进程退出后数据不会保留
 func New[T any](retentionPolicy time.Duration) *TSDB[T] {
-	store := newShard[T]()
-	idx := newIndex()
+	return newTSDB[T](retentionPolicy, nil)
+}
+
+// Open 创建一个带 WAL 的 TSDB：WritePoints 会先把数据 fsync 到 dir 下的 WAL
+// 再返回，dir 里已经存在的 WAL 会在这里被回放，恢复出崩溃前的数据
+func Open[T any](dir string, retentionPolicy time.Duration) (*TSDB[T], error) {
+	w, err := newWAL[T](dir, defaultWALSegmentSize, gobEncodeValue[T], gobDecodeValue[T])
+	if err != nil {
+		return nil, err
+	}
+
+	db := newTSDB[T](retentionPolicy, w)
+
+	err = w.replay(func(typ byte, payload []byte) {
+		switch typ {
+		case recordSeries:
+			id, tags, err := decodeSeriesRecord(payload)
+			if err != nil {
+				return
+			}
+			db.idx.restoreSeries(id, tags)
+		case recordSamples:
+			samples, err := w.decodeSamplesRecord(payload)
+			if err != nil {
+				return
+			}
+			db.restoreSamples(samples)
+		case recordTombstone:
+			key, iv, err := decodeTombstoneRecord(payload)
+			if err != nil {
+				return
+			}
+			db.tombstones.add(key, iv)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
 
+	// 回放恢复的历史数据有多老是不一定的，如果已经超过 headWindow 就先把它
+	// 整个封存成一个（可能偏大的）block，这样新写入不会跟历史数据混在一个
+	// 无限增长的 head 里；之后正常的 compaction 会继续把它跟后面的 block
+	// 往上一层合并
+	db.maybeRotateHead()
+
+	return db, nil
+}
+
+func newTSDB[T any](retentionPolicy time.Duration, w *wal[T]) *TSDB[T] {
 	stop := make(chan struct{})
-	db := &TSDB[T]{retentionPolicy: retentionPolicy, store: store, idx: idx, stop: stop}
+	db := &TSDB[T]{
+		retentionPolicy: retentionPolicy,
+		idx:             newIndex(),
+		store:           newShard[T](),
+		window:          newHeadBounds(),
+		wal:             w,
+		tombstones:      newTombstones(),
+		stop:            stop,
+	}
 
 	go db.gc()
 
 	return db
 }
 
+// restoreSamples 把 WAL 回放出来的样本直接写回内存存储，不再经过 WAL（避免
+// 重放的时候又把同一批数据追加写一遍）
+func (db *TSDB[T]) restoreSamples(samples []walSample[T]) {
+	values := make(map[string][]Value[T], len(samples))
+	for _, s := range samples {
+		key, ok := db.idx.seriesKey(s.seriesID)
+		if !ok {
+			continue
+		}
+		values[key] = append(values[key], s.value)
+		db.window.observe(s.value.UnixNano)
+	}
+	db.store.writeMulti(values)
+}
+
 func (db *TSDB[T]) WritePoints(points []Point[T]) error {
 	if db.isClosed {
 		return ErrDBClosed
 	}
 
 	seriesTags := make(map[string][]Tag, len(points))
-	values := make(map[string][]value[T], len(points))
+	values := make(map[string][]Value[T], len(points))
 	for _, point := range points {
 		s := point.Series()
 		if len(s) == 0 {
 			return ErrPointMissingTag
 		}
 
-		v := value[T]{unixNano: point.time.UnixNano(), v: point.field}
+		v := Value[T]{UnixNano: point.time.UnixNano(), V: point.field}
 		values[s] = append(values[s], v)
 
 		if _, ok := seriesTags[s]; ok {
@@ -48,8 +135,131 @@ func (db *TSDB[T]) WritePoints(points []Point[T]) error {
 		seriesTags[s] = point.tags
 	}
 
-	db.idx.createSeriesIfNotExists(seriesTags)
-	db.store.writeMulti(values)
+	// headMu 的读锁由 writeToHead 在整个读-改过程中一直持有（原因见它的注释），
+	// 它返回时已经放锁，这里再去触发轮转检查。
+	if err := db.writeToHead(seriesTags, values); err != nil {
+		return err
+	}
+
+	db.maybeRotateHead()
+
+	return nil
+}
+
+// writeToHead 把这次写入应用到当前 head 的 idx/store/window 上。整个读-改过程
+// 都要攥着 headMu 的读锁：rotateHead 拿写锁之后会把 store seal 掉再换成一个
+// 新的，如果这里提前放锁，store 可能在 writeMulti 真正执行前就已经被 seal，
+// 写入会落到一个已经冻结的 shard 上。headMu 是读写锁，多个写入可以同时持有
+// 读锁，只要还有人持有读锁，rotateHead 就进不去，所以这里一直握到这次写入涉及 store/window
+// 的操作全部做完为止，用 defer 保证即便中途出错或 panic 也不会漏掉 RUnlock。
+func (db *TSDB[T]) writeToHead(seriesTags map[string][]Tag, values map[string][]Value[T]) error {
+	db.headMu.RLock()
+	defer db.headMu.RUnlock()
+
+	idx, store, window := db.idx, db.store, db.window
+
+	newSeries := idx.createSeriesIfNotExists(seriesTags)
+
+	if db.wal != nil {
+		if err := db.writeWAL(idx, newSeries, seriesTags, values); err != nil {
+			return err
+		}
+	}
+
+	store.writeMulti(values)
+	for _, vs := range values {
+		for _, v := range vs {
+			window.observe(v.UnixNano)
+		}
+	}
+
+	return nil
+}
+
+// writeWAL 把这次写入新出现的系列和全部样本落到 WAL，fsync 成功之后
+// WritePoints 才会返回，这样进程崩溃也不会丢已经 ack 的写入
+func (db *TSDB[T]) writeWAL(idx *index, newSeries map[string]int, seriesTags map[string][]Tag, values map[string][]Value[T]) error {
+	for key, id := range newSeries {
+		if err := db.wal.appendSeries(id, seriesTags[key]); err != nil {
+			return err
+		}
+	}
+
+	samples := make([]walSample[T], 0, len(values))
+	for key, vs := range values {
+		id, ok := idx.seriesID(key)
+		if !ok {
+			continue
+		}
+		for _, v := range vs {
+			samples = append(samples, walSample[T]{seriesID: id, value: v})
+		}
+	}
+
+	return db.wal.appendSamples(samples)
+}
+
+// Checkpoint 把当前还存活的数据重写成一个紧凑的新 segment，并删除所有更老的
+// segment，避免 WAL 随着运行时间无限增长。这里必须拿 headMu 的写锁而不是读锁
+// 来做快照：writeToHead 在 WAL 追加和 store.writeMulti 之间也只握着读锁，如果
+// Checkpoint 同样只拿读锁，两者可以同时持有读锁交替执行，快照就可能正好拍在
+// 某次写入"WAL 已经 fsync、store 还没写"的中间状态——这次写入会被 acked 给
+// 调用方，但新 segment 里既没有它、旧 segment 又被删掉了，数据就丢了。拿写锁
+// 能保证快照发生时，所有已经开始的 writeToHead 都已经完整跑完（WAL 和 store
+// 两边都写完了），还没开始的也进不来
+func (db *TSDB[T]) Checkpoint() error {
+	if db.wal == nil {
+		return nil
+	}
+
+	db.headMu.Lock()
+	defer db.headMu.Unlock()
+
+	return db.wal.checkpoint(db.idx, db.store, db.blocks, db.tombstones)
+}
+
+// Delete 把 matchers 命中的系列在 [min, max] 窗口内的样本标记为删除。这里只
+// 追加一个 tombstone 区间，不会立刻重写底层存储；真正的物理清理交给 gc 里的
+// compaction。matchers 要在 head 和每个 block 各自的索引里分别求值，因为一个
+// 系列可能只存在于某个老的 block 里
+func (db *TSDB[T]) Delete(matchers []LabelMatcher, min, max time.Time) error {
+	db.headMu.RLock()
+	idx := db.idx
+	blocks := append([]*block[T]{}, db.blocks...)
+	db.headMu.RUnlock()
+
+	keys := make(map[string]struct{})
+	collect := func(idx *index) error {
+		postings, err := idx.postingsForMatchers(matchers)
+		if err != nil {
+			return err
+		}
+		for postings.Next() {
+			if key, ok := idx.seriesKey(postings.At()); ok {
+				keys[key] = struct{}{}
+			}
+		}
+		return postings.Err()
+	}
+
+	if err := collect(idx); err != nil {
+		return err
+	}
+	for _, b := range blocks {
+		if err := collect(b.idx); err != nil {
+			return err
+		}
+	}
+
+	iv := Interval{Mint: min.UnixNano(), Maxt: max.UnixNano()}
+	for key := range keys {
+		if db.wal != nil {
+			if err := db.wal.appendTombstone(key, iv); err != nil {
+				return err
+			}
+		}
+		db.tombstones.add(key, iv)
+	}
 
 	return nil
 }
@@ -57,6 +267,44 @@ func (db *TSDB[T]) WritePoints(points []Point[T]) error {
 func (db *TSDB[T]) Stop() {
 	db.stop <- struct{}{}
 	db.isClosed = true
+
+	if db.wal != nil {
+		_ = db.wal.close()
+	}
+}
+
+// maybeRotateHead 检查当前 head 的时间跨度有没有超过 defaultHeadWindow，
+// 超过的话就把它封存成一个不可变 block，换一个全新的 head 继续接收写入
+func (db *TSDB[T]) maybeRotateHead() {
+	db.headMu.RLock()
+	window := db.window
+	db.headMu.RUnlock()
+
+	min, max, ok := window.span()
+	if !ok || time.Duration(max-min) < defaultHeadWindow {
+		return
+	}
+
+	db.rotateHead(window)
+}
+
+// rotateHead 原子地把当前 head 换成一个全新的 shard+index，旧的 head 被封存
+// 成一个不可变 block 追加到 blocks 列表末尾。expected 用来判断 head 是不是
+// 已经被别的协程抢先轮转过了，避免同一个 head 被封存两次
+func (db *TSDB[T]) rotateHead(expected *headBounds) {
+	db.headMu.Lock()
+	defer db.headMu.Unlock()
+
+	if db.window != expected {
+		return
+	}
+
+	sealed := sealBlock(db.idx, db.store, db.window)
+
+	db.idx = newIndex()
+	db.store = newShard[T]()
+	db.window = newHeadBounds()
+	db.blocks = append(db.blocks, sealed)
 }
 
 func (db *TSDB[T]) gc() {
@@ -68,8 +316,103 @@ func (db *TSDB[T]) gc() {
 		case <-db.stop:
 			return
 		case <-ticker.C:
-			remove := time.Now().Add(-db.retentionPolicy).UnixNano()
-			db.store.removeBefore(remove)
+			db.maybeRotateHead()
+
+			cutoff := time.Now().Add(-db.retentionPolicy).UnixNano()
+			db.dropExpiredBlocks(cutoff)
+			db.tombstones.removeBefore(cutoff)
+
+			if db.wal != nil {
+				_ = db.wal.removeSegmentsBefore(cutoff)
+			}
+
+			db.purgeTombstoned(tombstonePurgeThreshold)
+			db.compact()
 		}
 	}
 }
+
+// dropExpiredBlocks 把 maxTime 早于 cutoff 的 block 整个丢弃，不需要再像以前
+// 那样逐个系列扫描 removeBefore，retention 删除就变成了 O(block 数量) 的操作。
+// head 还没被封存过，依然要照老办法逐系列裁剪
+func (db *TSDB[T]) dropExpiredBlocks(cutoff int64) {
+	db.headMu.Lock()
+	defer db.headMu.Unlock()
+
+	kept := db.blocks[:0:0]
+	for _, b := range db.blocks {
+		if b.maxTime < cutoff {
+			continue
+		}
+		kept = append(kept, b)
+	}
+	db.blocks = kept
+
+	db.store.removeBefore(cutoff)
+}
+
+// compact 把同一层级里排在最前面、凑够 fanout 数量的 block 合并成下一层更大
+// 的 block，按 2h -> 6h -> 1d 的节奏收敛
+func (db *TSDB[T]) compact() {
+	db.headMu.Lock()
+	defer db.headMu.Unlock()
+
+	for tier, t := range compactionTiers {
+		var group []*block[T]
+		for _, b := range db.blocks {
+			if b.tier != tier {
+				continue
+			}
+			group = append(group, b)
+			if len(group) == t.fanout {
+				break
+			}
+		}
+		if len(group) < t.fanout {
+			continue
+		}
+
+		merged := mergeBlocks(group)
+		merged.tier = tier + 1
+
+		db.blocks = replaceBlocks(db.blocks, group, merged)
+	}
+}
+
+// purgeTombstoned 把覆盖率超过 threshold 的系列里被 tombstone 标记的样本从
+// 当前 head 里物理删掉，这样长期被打了很多删除标记的系列不会一直靠
+// valuesBetween 过滤。已经封存的 block 是不可变的，不在这里处理，它们的
+// tombstone 样本只能继续靠查询路径上的 filterTombstoned 过滤掉
+func (db *TSDB[T]) purgeTombstoned(threshold float64) {
+	db.headMu.RLock()
+	store := db.store
+	db.headMu.RUnlock()
+
+	for _, key := range db.tombstones.keys() {
+		db.purgeTombstonedSeries(store, key, threshold)
+	}
+}
+
+func (db *TSDB[T]) purgeTombstonedSeries(store *shard[T], key string, threshold float64) {
+	intervals := db.tombstones.get(key)
+	if len(intervals) == 0 {
+		return
+	}
+
+	all := store.valuesBetween(key, math.MinInt64, math.MaxInt64)
+	if len(all) == 0 {
+		return
+	}
+
+	var covered int
+	for _, v := range all {
+		if intervals.InBounds(v.UnixNano) {
+			covered++
+		}
+	}
+	if float64(covered)/float64(len(all)) <= threshold {
+		return
+	}
+
+	store.rewrite(key, filterTombstoned(db.tombstones, key, all))
+}
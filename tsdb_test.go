@@ -0,0 +1,34 @@
+package tsdb
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestWritePointsConcurrentWithRotateHeadDoesNotPanic 覆盖一个曾经出现过的
+// 竞态：WritePoints 读出 idx/store 之后先放锁，再去调用 store.writeMulti，如果
+// 中间插入了一次 rotateHead，store 会被 seal 掉（entry.head 被置成 nil），
+// 之后的 writeMulti 就会在 nil head 上 panic。现在 WritePoints 必须在拿到的
+// store/window 这份快照上把所有操作做完才放锁，rotateHead 才不会在它中途把
+// 这份快照 seal 掉。
+func TestWritePointsConcurrentWithRotateHeadDoesNotPanic(t *testing.T) {
+	db := New[float64](time.Hour)
+	defer db.Stop()
+
+	// 时间戳跨度故意超过 defaultHeadWindow，让并发的 WritePoints 自己在结尾触发
+	// maybeRotateHead/rotateHead，而不是单独模拟一次轮转
+	var wg sync.WaitGroup
+	for i := 0; i < 200; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			t := time.Duration(i) * (defaultHeadWindow / 50)
+			_ = db.WritePoints([]Point[float64]{
+				NewPoint([]Tag{{Key: "host", Value: "a"}}, time.Unix(0, t.Nanoseconds()), float64(i)),
+			})
+		}(i)
+	}
+
+	wg.Wait()
+}
@@ -0,0 +1,617 @@
+package tsdb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	defaultWALSegmentSize = 128 * 1024 * 1024
+
+	recordSeries    byte = 1
+	recordSamples   byte = 2
+	recordTombstone byte = 3
+)
+
+// errCorruptRecord 表示一条记录的长度或 CRC 校验对不上，replay 遇到它就会
+// 停下来，把这里当成写入被中断（比如进程崩溃）时的尾巴
+var errCorruptRecord = errors.New("tsdb: corrupt wal record")
+
+// walSample 是 WAL 里记录的一条样本：属于哪个系列 ID，以及它的值
+type walSample[T any] struct {
+	seriesID int
+	value    Value[T]
+}
+
+// walSegment 是 WAL 的一个分段文件：只追加写，每条记录是
+// [1 byte type][4 byte length][payload][4 byte crc32]
+type walSegment struct {
+	id      int
+	path    string
+	f       *os.File
+	size    int64
+	maxTime int64 // 这个 segment 里最新样本的时间，gc 按它判断能不能整段删除
+}
+
+func walSegmentPath(dir string, id int) string {
+	return filepath.Join(dir, fmt.Sprintf("%08d.wal", id))
+}
+
+func createWALSegment(dir string, id int) (*walSegment, error) {
+	path := walSegmentPath(dir, id)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &walSegment{id: id, path: path, f: f}, nil
+}
+
+func openWALSegment(dir string, id int) (*walSegment, error) {
+	path := walSegmentPath(dir, id)
+	f, err := os.OpenFile(path, os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &walSegment{id: id, path: path, f: f}, nil
+}
+
+func listWALSegmentIDs(dir string) ([]int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []int
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".wal") {
+			continue
+		}
+		id, err := strconv.Atoi(strings.TrimSuffix(e.Name(), ".wal"))
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
+
+// replay 从头读这个 segment 的记录并依次喂给 fn，读到第一条长度或 CRC 对不上
+// 的记录（或者干净的 EOF）就停下来；如果是被截断/损坏的尾巴，把文件 truncate
+// 到最后一条完整记录的位置，这样后续追加写不会夹在一条坏记录中间
+func (seg *walSegment) replay(fn func(typ byte, payload []byte)) error {
+	if _, err := seg.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(seg.f)
+
+	var offset int64
+	for {
+		typ, payload, err := readRecord(r)
+		if err != nil {
+			break // 干净的 EOF，或者尾部被截断/损坏，都停在这里
+		}
+
+		fn(typ, payload)
+		offset += recordSize(len(payload))
+		if typ == recordSamples {
+			if t := maxSampleTime(payload); t > seg.maxTime {
+				seg.maxTime = t
+			}
+		}
+	}
+
+	if err := seg.f.Truncate(offset); err != nil {
+		return err
+	}
+	if _, err := seg.f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	seg.size = offset
+	return nil
+}
+
+func recordSize(payloadLen int) int64 {
+	return int64(1 + 4 + payloadLen + 4)
+}
+
+// maxSampleTime 扫一遍一条 samplesRecord 拿到里面最大的时间戳，不需要知道 T
+// 具体是什么类型（直接跳过 value 的字节），replay 时用它来重建 segment 的
+// maxTime，这样重启之后 gc 也能正确判断哪些老 segment 可以整段删除
+func maxSampleTime(payload []byte) int64 {
+	b := payload
+	count, n := binary.Uvarint(b)
+	if n <= 0 {
+		return 0
+	}
+	b = b[n:]
+
+	var maxT int64
+	for i := uint64(0); i < count; i++ {
+		_, n := binary.Uvarint(b) // seriesID
+		if n <= 0 {
+			return maxT
+		}
+		b = b[n:]
+
+		tRaw, n := binary.Uvarint(b)
+		if n <= 0 {
+			return maxT
+		}
+		b = b[n:]
+		if t := zigzagDecode(tRaw); t > maxT {
+			maxT = t
+		}
+
+		vlen, n := binary.Uvarint(b)
+		if n <= 0 || uint64(n)+vlen > uint64(len(b)) {
+			return maxT
+		}
+		b = b[n+int(vlen):]
+	}
+	return maxT
+}
+
+func writeRecord(w io.Writer, typ byte, payload []byte) (int, error) {
+	var header [5]byte
+	header[0] = typ
+	binary.BigEndian.PutUint32(header[1:], uint32(len(payload)))
+
+	var crcBuf [4]byte
+	binary.BigEndian.PutUint32(crcBuf[:], crc32.ChecksumIEEE(payload))
+
+	n1, err := w.Write(header[:])
+	if err != nil {
+		return n1, err
+	}
+	n2, err := w.Write(payload)
+	if err != nil {
+		return n1 + n2, err
+	}
+	n3, err := w.Write(crcBuf[:])
+	return n1 + n2 + n3, err
+}
+
+func readRecord(r io.Reader) (byte, []byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+
+	typ := header[0]
+	length := binary.BigEndian.Uint32(header[1:])
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, errCorruptRecord
+	}
+
+	var crcBuf [4]byte
+	if _, err := io.ReadFull(r, crcBuf[:]); err != nil {
+		return 0, nil, errCorruptRecord
+	}
+	if binary.BigEndian.Uint32(crcBuf[:]) != crc32.ChecksumIEEE(payload) {
+		return 0, nil, errCorruptRecord
+	}
+
+	return typ, payload, nil
+}
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	return append(buf, tmp[:n]...)
+}
+
+func encodeSeriesRecord(id int, tags []Tag) []byte {
+	buf := appendUvarint(nil, uint64(id))
+	buf = appendUvarint(buf, uint64(len(tags)))
+	for _, t := range tags {
+		buf = appendUvarint(buf, uint64(len(t.Key)))
+		buf = append(buf, t.Key...)
+		buf = appendUvarint(buf, uint64(len(t.Value)))
+		buf = append(buf, t.Value...)
+	}
+	return buf
+}
+
+func decodeSeriesRecord(b []byte) (id int, tags []Tag, err error) {
+	u, n := binary.Uvarint(b)
+	if n <= 0 {
+		return 0, nil, errCorruptRecord
+	}
+	id, b = int(u), b[n:]
+
+	count, n := binary.Uvarint(b)
+	if n <= 0 {
+		return 0, nil, errCorruptRecord
+	}
+	b = b[n:]
+
+	tags = make([]Tag, 0, count)
+	for i := uint64(0); i < count; i++ {
+		key, rest, err := readLenPrefixed(b)
+		if err != nil {
+			return 0, nil, err
+		}
+		b = rest
+
+		val, rest, err := readLenPrefixed(b)
+		if err != nil {
+			return 0, nil, err
+		}
+		b = rest
+
+		tags = append(tags, Tag{Key: key, Value: val})
+	}
+	return id, tags, nil
+}
+
+func encodeTombstoneRecord(key string, iv Interval) []byte {
+	buf := appendUvarint(nil, uint64(len(key)))
+	buf = append(buf, key...)
+	buf = appendUvarint(buf, zigzagEncode(iv.Mint))
+	buf = appendUvarint(buf, zigzagEncode(iv.Maxt))
+	return buf
+}
+
+func decodeTombstoneRecord(b []byte) (string, Interval, error) {
+	key, rest, err := readLenPrefixed(b)
+	if err != nil {
+		return "", Interval{}, err
+	}
+	b = rest
+
+	mint, n := binary.Uvarint(b)
+	if n <= 0 {
+		return "", Interval{}, errCorruptRecord
+	}
+	b = b[n:]
+
+	maxt, n := binary.Uvarint(b)
+	if n <= 0 {
+		return "", Interval{}, errCorruptRecord
+	}
+
+	return key, Interval{Mint: zigzagDecode(mint), Maxt: zigzagDecode(maxt)}, nil
+}
+
+func readLenPrefixed(b []byte) (string, []byte, error) {
+	l, n := binary.Uvarint(b)
+	if n <= 0 || uint64(n)+l > uint64(len(b)) {
+		return "", nil, errCorruptRecord
+	}
+	b = b[n:]
+	return string(b[:l]), b[l:], nil
+}
+
+// wal 管理一串按 id 递增排列的 segment：写入 fsync 到最后一个 segment，超过
+// segmentSize 就轮转出一个新的
+type wal[T any] struct {
+	mu sync.Mutex
+
+	dir         string
+	segmentSize int64
+
+	encodeValue func(T) ([]byte, error)
+	decodeValue func([]byte) (T, error)
+
+	segments []*walSegment
+}
+
+func newWAL[T any](dir string, segmentSize int64, encodeValue func(T) ([]byte, error), decodeValue func([]byte) (T, error)) (*wal[T], error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	w := &wal[T]{dir: dir, segmentSize: segmentSize, encodeValue: encodeValue, decodeValue: decodeValue}
+
+	ids, err := listWALSegmentIDs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ids) == 0 {
+		seg, err := createWALSegment(dir, 0)
+		if err != nil {
+			return nil, err
+		}
+		w.segments = []*walSegment{seg}
+		return w, nil
+	}
+
+	for _, id := range ids {
+		seg, err := openWALSegment(dir, id)
+		if err != nil {
+			return nil, err
+		}
+		w.segments = append(w.segments, seg)
+	}
+	return w, nil
+}
+
+// replay 依次回放所有 segment 里的记录，series 记录和 samples 记录都原样交给
+// fn，由调用方（TSDB[T]）负责把它们灌回 idx 和 store
+func (w *wal[T]) replay(fn func(typ byte, payload []byte)) error {
+	for _, seg := range w.segments {
+		if err := seg.replay(fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *wal[T]) appendSeries(id int, tags []Tag) error {
+	return w.append(recordSeries, encodeSeriesRecord(id, tags), 0)
+}
+
+func (w *wal[T]) appendTombstone(key string, iv Interval) error {
+	return w.append(recordTombstone, encodeTombstoneRecord(key, iv), 0)
+}
+
+func (w *wal[T]) appendSamples(samples []walSample[T]) error {
+	if len(samples) == 0 {
+		return nil
+	}
+
+	payload, err := w.encodeSamplesRecord(samples)
+	if err != nil {
+		return err
+	}
+
+	var maxTime int64
+	for _, s := range samples {
+		if s.value.UnixNano > maxTime {
+			maxTime = s.value.UnixNano
+		}
+	}
+
+	return w.append(recordSamples, payload, maxTime)
+}
+
+func (w *wal[T]) append(typ byte, payload []byte, maxTime int64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	seg := w.segments[len(w.segments)-1]
+	if seg.size > 0 && seg.size+recordSize(len(payload)) > w.segmentSize {
+		next, err := createWALSegment(w.dir, seg.id+1)
+		if err != nil {
+			return err
+		}
+		w.segments = append(w.segments, next)
+		seg = next
+	}
+
+	n, err := writeRecord(seg.f, typ, payload)
+	if err != nil {
+		return err
+	}
+	seg.size += int64(n)
+	if maxTime > seg.maxTime {
+		seg.maxTime = maxTime
+	}
+
+	return seg.f.Sync()
+}
+
+func (w *wal[T]) encodeSamplesRecord(samples []walSample[T]) ([]byte, error) {
+	buf := appendUvarint(nil, uint64(len(samples)))
+	for _, s := range samples {
+		buf = appendUvarint(buf, uint64(s.seriesID))
+		buf = appendUvarint(buf, zigzagEncode(s.value.UnixNano))
+
+		vb, err := w.encodeValue(s.value.V)
+		if err != nil {
+			return nil, err
+		}
+		buf = appendUvarint(buf, uint64(len(vb)))
+		buf = append(buf, vb...)
+	}
+	return buf, nil
+}
+
+func (w *wal[T]) decodeSamplesRecord(b []byte) ([]walSample[T], error) {
+	count, n := binary.Uvarint(b)
+	if n <= 0 {
+		return nil, errCorruptRecord
+	}
+	b = b[n:]
+
+	samples := make([]walSample[T], 0, count)
+	for i := uint64(0); i < count; i++ {
+		id, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, errCorruptRecord
+		}
+		b = b[n:]
+
+		tRaw, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, errCorruptRecord
+		}
+		b = b[n:]
+
+		vlen, n := binary.Uvarint(b)
+		if n <= 0 || uint64(n)+vlen > uint64(len(b)) {
+			return nil, errCorruptRecord
+		}
+		b = b[n:]
+		vb := b[:vlen]
+		b = b[vlen:]
+
+		v, err := w.decodeValue(vb)
+		if err != nil {
+			return nil, errCorruptRecord
+		}
+
+		samples = append(samples, walSample[T]{
+			seriesID: int(id),
+			value:    Value[T]{UnixNano: zigzagDecode(tRaw), V: v},
+		})
+	}
+	return samples, nil
+}
+
+// removeSegmentsBefore 物理删除所有样本都早于 cutoff 的 segment，最后一个
+// segment 永远保留，因为它是当前可写的那个
+func (w *wal[T]) removeSegmentsBefore(cutoff int64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	kept := w.segments[:0:0]
+	for i, seg := range w.segments {
+		if i < len(w.segments)-1 && seg.maxTime != 0 && seg.maxTime < cutoff {
+			if err := seg.f.Close(); err != nil {
+				return err
+			}
+			if err := os.Remove(seg.path); err != nil {
+				return err
+			}
+			continue
+		}
+		kept = append(kept, seg)
+	}
+	w.segments = kept
+	return nil
+}
+
+// checkpoint 把 head 和所有 block 里当前存活的数据，连同 tombstones，重写成
+// 一个新的、紧凑的 segment，然后删除所有更老的 segment，避免 WAL 随着运行
+// 时间无限增长。head 里每个系列必须保留它在活着的 idx 里原本的 ID 不变——
+// checkpoint 之后对同一个系列的新写入仍然会沿用这份没有被替换过的 idx，
+// 如果这里给它分配了不一样的 ID，新写入的 recordSamples 就会引用一个这个
+// segment 里根本不存在的 seriesID，重启回放时会被 restoreSamples 悄悄丢掉。
+// 只有只存在于某个 block（block 各自独立编号，互相之间以及跟 head 之间都
+// 可能在数字上撞车）、从来没在 head 出现过的系列，才需要另外分配一个跟 head
+// 不冲突的 ID；重启时 Open 会把这个 segment 全部回放进一个新的 head，再按
+// headWindow 决定要不要重新封存成 block——原来的 block 边界不会被精确还原，
+// 但数据不会丢
+func (w *wal[T]) checkpoint(idx *index, store *shard[T], blocks []*block[T], ts *tombstones) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	nextID := w.segments[len(w.segments)-1].id + 1
+	seg, err := createWALSegment(w.dir, nextID)
+	if err != nil {
+		return err
+	}
+
+	old := w.segments
+	w.segments = []*walSegment{seg}
+
+	ids := make(map[string]int)
+	nextFreeID := 0
+	idx.forEach(func(id int, key string, _ []Tag) {
+		ids[key] = id
+		if id >= nextFreeID {
+			nextFreeID = id + 1
+		}
+	})
+
+	writeSeries := func(idxN *index, storeN *shard[T]) {
+		idxN.forEach(func(_ int, key string, tags []Tag) {
+			id, ok := ids[key]
+			if !ok {
+				id = nextFreeID
+				nextFreeID++
+				ids[key] = id
+			}
+
+			_, _ = writeRecord(seg.f, recordSeries, encodeSeriesRecord(id, tags))
+
+			values := storeN.valuesBetween(key, math.MinInt64, math.MaxInt64)
+			if len(values) == 0 {
+				return
+			}
+
+			samples := make([]walSample[T], len(values))
+			for i, v := range values {
+				samples[i] = walSample[T]{seriesID: id, value: v}
+				if v.UnixNano > seg.maxTime {
+					seg.maxTime = v.UnixNano
+				}
+			}
+
+			payload, err := w.encodeSamplesRecord(samples)
+			if err != nil {
+				return
+			}
+			_, _ = writeRecord(seg.f, recordSamples, payload)
+		})
+	}
+
+	writeSeries(idx, store)
+	for _, b := range blocks {
+		writeSeries(b.idx, b.store)
+	}
+
+	for _, key := range ts.keys() {
+		for _, iv := range ts.get(key) {
+			_, _ = writeRecord(seg.f, recordTombstone, encodeTombstoneRecord(key, iv))
+		}
+	}
+
+	if err := seg.f.Sync(); err != nil {
+		return err
+	}
+	fi, err := seg.f.Stat()
+	if err != nil {
+		return err
+	}
+	seg.size = fi.Size()
+
+	for _, s := range old {
+		if err := s.f.Close(); err != nil {
+			return err
+		}
+		if err := os.Remove(s.path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *wal[T]) close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, seg := range w.segments {
+		if err := seg.f.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// gobEncodeValue 是 encodeValue 的默认实现，用 encoding/gob 编码任意 T
+func gobEncodeValue[T any](v T) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// gobDecodeValue 是 decodeValue 的默认实现，配合 gobEncodeValue 使用
+func gobDecodeValue[T any](b []byte) (T, error) {
+	var v T
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&v); err != nil {
+		return v, err
+	}
+	return v, nil
+}
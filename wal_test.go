@@ -0,0 +1,191 @@
+package tsdb
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWALCrashRecoveryReplaysCommittedSamples(t *testing.T) {
+	dir := t.TempDir()
+
+	db, err := Open[float64](dir, time.Hour)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	err = db.WritePoints([]Point[float64]{
+		NewPoint([]Tag{{Key: "host", Value: "a"}}, time.Unix(0, 1), 1.5),
+		NewPoint([]Tag{{Key: "host", Value: "b"}}, time.Unix(0, 2), 2.5),
+	})
+	if err != nil {
+		t.Fatalf("WritePoints: %v", err)
+	}
+	db.Stop()
+
+	reopened, err := Open[float64](dir, time.Hour)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer reopened.Stop()
+
+	series, err := reopened.Query(
+		[]LabelMatcher{{Name: "host", Value: "a", Type: MatchEqual}},
+		time.Unix(0, 0), time.Unix(0, 10),
+	)
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if len(series) != 1 || len(series[0].Values) != 1 || series[0].Values[0].V != 1.5 {
+		t.Fatalf("want host=a to survive restart with value 1.5, got %+v", series)
+	}
+}
+
+// TestCheckpointPreservesIDsForWritesAfterCheckpoint 覆盖一个曾经出现过的
+// 回归：checkpoint 给每个系列重新分配 ID 之后，如果没对上 checkpoint 之后
+// 还会继续使用的那份活着的 idx，checkpoint 之后对已存在系列的新写入会在
+// 崩溃恢复时被静默丢弃
+func TestCheckpointPreservesIDsForWritesAfterCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+
+	db, err := Open[float64](dir, time.Hour)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	var points []Point[float64]
+	for i := 0; i < 10; i++ {
+		host := string(rune('a' + i))
+		points = append(points, NewPoint([]Tag{{Key: "host", Value: host}}, time.Unix(0, int64(i)), float64(i)))
+	}
+	if err := db.WritePoints(points); err != nil {
+		t.Fatalf("WritePoints: %v", err)
+	}
+
+	if err := db.Checkpoint(); err != nil {
+		t.Fatalf("Checkpoint: %v", err)
+	}
+
+	// 对一个 checkpoint 之前就存在的系列（host=e）再写一个新样本
+	if err := db.WritePoints([]Point[float64]{
+		NewPoint([]Tag{{Key: "host", Value: "e"}}, time.Unix(0, 100), 42.0),
+	}); err != nil {
+		t.Fatalf("WritePoints after checkpoint: %v", err)
+	}
+	db.Stop()
+
+	reopened, err := Open[float64](dir, time.Hour)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer reopened.Stop()
+
+	series, err := reopened.Query(
+		[]LabelMatcher{{Name: "host", Value: "e", Type: MatchEqual}},
+		time.Unix(0, 0), time.Unix(0, 1000),
+	)
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if len(series) != 1 {
+		t.Fatalf("want host=e series to exist after reopen, got %+v", series)
+	}
+
+	var found bool
+	for _, v := range series[0].Values {
+		if v.UnixNano == 100 && v.V == 42 {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("post-checkpoint sample for host=e was lost, got %+v", series[0].Values)
+	}
+}
+
+// TestCheckpointDoesNotLoseConcurrentlyAckedWrites 覆盖一个曾经出现过的
+// 回归：writeToHead 先把样本 fsync 进 WAL 再写 store，如果 Checkpoint 只拿
+// headMu 的读锁给 idx/store 拍快照，它可以跟一个正在进行中的 writeToHead
+// 同时持有读锁交替执行——快照可能正好拍在"WAL 已经 fsync、store 还没写"
+// 的中间状态，这次写入已经被 acked 给调用方，但快照里既没有它，旧 segment
+// 又会被 Checkpoint 删掉，重启之后这条写入就彻底丢了。Checkpoint 现在给
+// 快照拿的是写锁，能保证所有已经开始的 writeToHead 都已经完整跑完。
+func TestCheckpointDoesNotLoseConcurrentlyAckedWrites(t *testing.T) {
+	dir := t.TempDir()
+
+	db, err := Open[float64](dir, time.Hour)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	const writers = 20
+	const writesPerWriter = 20
+
+	var mu sync.Mutex
+	var acked []string
+
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < writesPerWriter; i++ {
+				host := fmt.Sprintf("writer-%d", w)
+				err := db.WritePoints([]Point[float64]{
+					NewPoint([]Tag{{Key: "host", Value: host}}, time.Unix(0, int64(i+1)), float64(i)),
+				})
+				if err != nil {
+					t.Errorf("WritePoints: %v", err)
+					return
+				}
+				mu.Lock()
+				acked = append(acked, host)
+				mu.Unlock()
+			}
+		}(w)
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < writers; i++ {
+			if err := db.Checkpoint(); err != nil {
+				t.Errorf("Checkpoint: %v", err)
+				return
+			}
+		}
+	}()
+
+	wg.Wait()
+	db.Stop()
+
+	reopened, err := Open[float64](dir, time.Hour)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer reopened.Stop()
+
+	mu.Lock()
+	wantCounts := make(map[string]int, writers)
+	for _, host := range acked {
+		wantCounts[host]++
+	}
+	mu.Unlock()
+
+	for host, want := range wantCounts {
+		series, err := reopened.Query(
+			[]LabelMatcher{{Name: "host", Value: host, Type: MatchEqual}},
+			time.Unix(0, 0), time.Unix(0, int64(writesPerWriter)+1),
+		)
+		if err != nil {
+			t.Fatalf("Query(%s): %v", host, err)
+		}
+		got := 0
+		if len(series) == 1 {
+			got = len(series[0].Values)
+		}
+		if got != want {
+			t.Fatalf("host=%s: want %d acked samples to survive restart, got %d", host, want, got)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package tsdb
+
+import (
+	"math"
+	"math/bits"
+)
+
+// noLeadingZeros 是 xorWriter 里表示 "还没有前一个窗口" 的哨兵值,
+// 前导零个数被截断到 31 以内（见 write），正常取值不会碰到它。
+const noLeadingZeros = 0xff
+
+// xorWriter 用 Gorilla 论文里的异或编码压缩一串 float64：第一个值原样写入，
+// 此后每个值与前一个值按位异或，全 0 则写一个 0 bit；否则写 1 bit，再视情况
+// 复用上一次的前导/末尾零窗口（1 bit + 有效位）或重新记录一个窗口
+// （1 bit + 5 bit 前导零个数 + 6 bit 有效位长度 + 有效位）。
+type xorWriter struct {
+	bw *bstreamWriter
+
+	n        int
+	v        float64
+	leading  uint8
+	trailing uint8
+}
+
+func newXorWriter(bw *bstreamWriter) *xorWriter {
+	return &xorWriter{bw: bw, leading: noLeadingZeros}
+}
+
+func (w *xorWriter) write(v float64) {
+	if w.n == 0 {
+		w.bw.writeBits(math.Float64bits(v), 64)
+		w.v = v
+		w.n++
+		return
+	}
+
+	xor := math.Float64bits(v) ^ math.Float64bits(w.v)
+	if xor == 0 {
+		w.bw.writeBit(false)
+		w.v = v
+		w.n++
+		return
+	}
+	w.bw.writeBit(true)
+
+	leading := uint8(bits.LeadingZeros64(xor))
+	trailing := uint8(bits.TrailingZeros64(xor))
+	if leading > 31 {
+		// 5 bit 最多只能表示到 31，截断不影响正确性：有效位窗口仍然覆盖所有为 1 的 bit。
+		leading = 31
+	}
+
+	if w.leading != noLeadingZeros && leading >= w.leading && trailing >= w.trailing {
+		w.bw.writeBit(false)
+		meaningful := 64 - w.leading - w.trailing
+		w.bw.writeBits(xor>>w.trailing, int(meaningful))
+	} else {
+		w.leading, w.trailing = leading, trailing
+
+		w.bw.writeBit(true)
+		w.bw.writeBits(uint64(leading), 5)
+
+		meaningful := 64 - leading - trailing
+		w.bw.writeBits(uint64(meaningful-1), 6)
+		w.bw.writeBits(xor>>trailing, int(meaningful))
+	}
+
+	w.v = v
+	w.n++
+}
+
+// xorReader 读取 xorWriter 产生的 float64 序列。
+type xorReader struct {
+	br *bstreamReader
+
+	n        int
+	v        float64
+	leading  uint8
+	trailing uint8
+}
+
+func newXorReader(br *bstreamReader) *xorReader {
+	return &xorReader{br: br}
+}
+
+func (r *xorReader) next() (float64, bool) {
+	if r.n == 0 {
+		u, ok := r.br.readBits(64)
+		if !ok {
+			return 0, false
+		}
+		r.v = math.Float64frombits(u)
+		r.n++
+		return r.v, true
+	}
+
+	ctrl, ok := r.br.readBit()
+	if !ok {
+		return 0, false
+	}
+	if !ctrl {
+		r.n++
+		return r.v, true
+	}
+
+	newWindow, ok := r.br.readBit()
+	if !ok {
+		return 0, false
+	}
+	if newWindow {
+		leadingBits, ok := r.br.readBits(5)
+		if !ok {
+			return 0, false
+		}
+		meaningfulBits, ok := r.br.readBits(6)
+		if !ok {
+			return 0, false
+		}
+		r.leading = uint8(leadingBits)
+		r.trailing = 64 - r.leading - uint8(meaningfulBits+1)
+	}
+
+	meaningful := 64 - r.leading - r.trailing
+	u, ok := r.br.readBits(int(meaningful))
+	if !ok {
+		return 0, false
+	}
+	xor := u << r.trailing
+	r.v = math.Float64frombits(math.Float64bits(r.v) ^ xor)
+	r.n++
+	return r.v, true
+}